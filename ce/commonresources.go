@@ -85,7 +85,7 @@ func createResource(base, auth string, name string, resourcebytes []byte) ([]byt
 	curl := fmt.Sprintf("%s", curlCmd)
 	resp, err := client.Do(req)
 	if err != nil {
-		return bodybytes, resp.StatusCode, curl, err
+		return bodybytes, -1, curl, err
 	}
 	bodybytes, err = ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
@@ -131,7 +131,7 @@ func DeleteResource(base, auth, resourceName string) ([]byte, int, string, error
 	curl := fmt.Sprintf("%s", curlCmd)
 	resp, err := client.Do(req)
 	if err != nil {
-		return bodybytes, resp.StatusCode, curl, err
+		return bodybytes, -1, curl, err
 	}
 	bodybytes, err = ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
@@ -166,7 +166,7 @@ func GetResourceDefinition(base, auth string, resourceName string, details bool)
 	curl := fmt.Sprintf("%s", curlCmd)
 	resp, err := client.Do(req)
 	if err != nil {
-		return bodybytes, resp.StatusCode, curl, err
+		return bodybytes, -1, curl, err
 	}
 	bodybytes, err = ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
@@ -190,7 +190,7 @@ func ResourcesList(base, auth string) ([]byte, int, string, error) {
 	curl := fmt.Sprintf("%s", curlCmd)
 	resp, err := client.Do(req)
 	if err != nil {
-		return bodybytes, resp.StatusCode, curl, err
+		return bodybytes, -1, curl, err
 	}
 	bodybytes, err = ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
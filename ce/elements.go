@@ -411,7 +411,7 @@ func GetElementInstances(base, auth, elementid string) ([]byte, int, string, err
 	curl := fmt.Sprintf("%s", curlCmd)
 	resp, err := client.Do(req)
 	if err != nil {
-		return bodybytes, resp.StatusCode, curl, err
+		return bodybytes, -1, curl, err
 	}
 	bodybytes, err = ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
@@ -0,0 +1,339 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Files written by ArchiveFormulaExecutions
+const (
+	ArchiveExecutionsFile = "executions.ndjson"
+	ArchiveStepsFile      = "steps.ndjson"
+	ArchiveValuesFile     = "values.ndjson"
+	ArchiveCheckpointFile = "checkpoint.json"
+)
+
+// DefaultArchivePageSize is the number of executions requested per page
+const DefaultArchivePageSize = 200
+
+// ArchiveOptions selects what ArchiveFormulaExecutions exports; with no
+// FormulaIDs every Formula in the account is archived
+type ArchiveOptions struct {
+	FormulaIDs []int
+	PageSize   int
+	Debug      bool
+}
+
+// ArchiveCheckpoint records, per Formula Instance, the last exported
+// execution and the executions that were still running when last seen, and
+// the size of each archive file when it was saved
+type ArchiveCheckpoint struct {
+	Updated   time.Time                          `json:"updated"`
+	Instances map[string]InstanceArchivePosition `json:"instances"`
+	Sizes     map[string]int64                   `json:"sizes,omitempty"`
+}
+
+// InstanceArchivePosition is the archive position of one Formula Instance
+type InstanceArchivePosition struct {
+	LastExecutionID int   `json:"lastExecutionId"`
+	Pending         []int `json:"pending,omitempty"`
+}
+
+// ArchivedExecution is a line of the executions file
+type ArchivedExecution struct {
+	FormulaID int `json:"formulaId"`
+	FormulaInstanceExecution
+}
+
+// ArchivedStepExecution is a line of the steps file, without its values
+type ArchivedStepExecution struct {
+	ExecutionID int `json:"executionId"`
+	StepExecution
+}
+
+// ArchivedStepExecutionValue is a line of the values file
+type ArchivedStepExecutionValue struct {
+	ExecutionID     int    `json:"executionId"`
+	StepExecutionID int    `json:"stepExecutionId"`
+	StepName        string `json:"stepName"`
+	StepExecutionValue
+}
+
+// ArchiveResult counts the records written by an archive run
+type ArchiveResult struct {
+	Executions int `json:"executions"`
+	Steps      int `json:"steps"`
+	Values     int `json:"values"`
+	Pending    int `json:"pending"`
+}
+
+// ReadArchiveCheckpoint reads the checkpoint in dir, returning an empty
+// checkpoint if there is none
+func ReadArchiveCheckpoint(dir string) (ArchiveCheckpoint, error) {
+	checkpoint := ArchiveCheckpoint{Instances: make(map[string]InstanceArchivePosition)}
+	b, err := ioutil.ReadFile(filepath.Join(dir, ArchiveCheckpointFile))
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	err = json.Unmarshal(b, &checkpoint)
+	if checkpoint.Instances == nil {
+		checkpoint.Instances = make(map[string]InstanceArchivePosition)
+	}
+	return checkpoint, err
+}
+
+// WriteArchiveCheckpoint replaces the checkpoint in dir
+func WriteArchiveCheckpoint(dir string, checkpoint ArchiveCheckpoint) error {
+	b, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ArchiveCheckpointFile+".tmp")
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ArchiveCheckpointFile))
+}
+
+// ArchiveFormulaExecutions appends the finished executions of the selected
+// Formulas' Instances since the checkpoint in dir, with their step executions
+// and step values, to newline-delimited JSON files in dir. Executions still
+// running are exported by a later run once finished. The checkpoint is saved
+// after each execution, so an interrupted run resumes where it stopped;
+// records written after the last checkpoint are truncated first.
+func ArchiveFormulaExecutions(base, auth string, dir string, opts ArchiveOptions) (ArchiveResult, error) {
+	var result ArchiveResult
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return result, err
+	}
+	checkpoint, err := ReadArchiveCheckpoint(dir)
+	if err != nil {
+		return result, err
+	}
+	formulas, err := archiveFormulas(base, auth, opts.FormulaIDs)
+	if err != nil {
+		return result, err
+	}
+
+	files := make(map[string]*os.File)
+	sizes := make(map[string]int64)
+	for _, name := range []string{ArchiveExecutionsFile, ArchiveStepsFile, ArchiveValuesFile} {
+		path := filepath.Join(dir, name)
+		if size, ok := checkpoint.Sizes[name]; ok {
+			// drop the records of an execution whose checkpoint was never saved
+			info, err := os.Stat(path)
+			if err == nil && info.Size() > size {
+				err = os.Truncate(path, size)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return result, err
+			}
+		}
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return result, err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return result, err
+		}
+		files[name] = f
+		sizes[name] = info.Size()
+	}
+	checkpoint.Sizes = sizes
+
+	for _, f := range formulas {
+		for _, i := range f.Instances {
+			id := strconv.Itoa(i.ID)
+			position := checkpoint.Instances[id]
+			executions, err := executionsSince(base, auth, id, position, opts.PageSize)
+			if err != nil {
+				return result, fmt.Errorf("formula instance %v: %s", i.ID, err)
+			}
+			if opts.Debug {
+				log.Printf("Archiving %v executions of formula instance %v", len(executions), i.ID)
+			}
+
+			// executions still to be handled keep their place in Pending,
+			// so the checkpoint can be saved after every execution
+			unhandled := make(map[int]bool)
+			for _, e := range executions {
+				unhandled[e.ID] = true
+			}
+			var pending []int
+			next := position
+			for _, e := range executions {
+				delete(unhandled, e.ID)
+				if e.ID > next.LastExecutionID {
+					next.LastExecutionID = e.ID
+				}
+				if !ExecutionFinished(e.Status) {
+					pending = append(pending, e.ID)
+				} else {
+					if e.FormulaInstanceID == 0 {
+						e.FormulaInstanceID = i.ID
+					}
+					err = archiveExecution(base, auth, f.ID, e, files, sizes, &result)
+					if err != nil {
+						return result, fmt.Errorf("execution %v: %s", e.ID, err)
+					}
+				}
+				next.Pending = append([]int{}, pending...)
+				for _, p := range position.Pending {
+					if unhandled[p] {
+						next.Pending = append(next.Pending, p)
+					}
+				}
+				checkpoint.Instances[id] = next
+				checkpoint.Updated = time.Now().UTC()
+				err = WriteArchiveCheckpoint(dir, checkpoint)
+				if err != nil {
+					return result, err
+				}
+			}
+			if len(executions) == 0 && len(position.Pending) > 0 {
+				// pending executions that are no longer listed are dropped
+				checkpoint.Instances[id] = InstanceArchivePosition{LastExecutionID: position.LastExecutionID}
+				checkpoint.Updated = time.Now().UTC()
+				err = WriteArchiveCheckpoint(dir, checkpoint)
+				if err != nil {
+					return result, err
+				}
+			}
+			result.Pending += len(pending)
+		}
+	}
+	return result, nil
+}
+
+// archiveFormulas returns the Formulas to archive with their Instances
+func archiveFormulas(base, auth string, formulaIDs []int) ([]Formula, error) {
+	if len(formulaIDs) == 0 {
+		formulabytes, status, _, err := FormulasList(base, auth)
+		if err != nil {
+			return nil, err
+		}
+		if status != 200 {
+			return nil, fmt.Errorf("Status code %v", status)
+		}
+		return CombinedFormulaAndInstances(formulabytes, base, auth)
+	}
+	var formulas []Formula
+	for _, id := range formulaIDs {
+		instances, err := GetInstancesOfFormula(id, base, auth)
+		if err != nil {
+			return nil, fmt.Errorf("formula %v: %s", id, err)
+		}
+		formulas = append(formulas, Formula{ID: id, Instances: instances})
+	}
+	return formulas, nil
+}
+
+// executionsSince pages through a Formula Instance's executions, returning,
+// oldest first, those after the position's last execution or still pending
+func executionsSince(base, auth, instanceID string, position InstanceArchivePosition, pageSize int) ([]FormulaInstanceExecution, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultArchivePageSize
+	}
+	pending := make(map[int]bool)
+	floor := position.LastExecutionID
+	for _, id := range position.Pending {
+		pending[id] = true
+		if id <= floor {
+			floor = id - 1
+		}
+	}
+
+	var executions []FormulaInstanceExecution
+	nextPage := ""
+	for {
+		page, token, err := GetFormulaInstanceExecutionsPage(base, auth, instanceID, pageSize, nextPage)
+		if err != nil {
+			return nil, err
+		}
+		older := true
+		for _, e := range page {
+			if e.ID > floor {
+				older = false
+			}
+			if e.ID > position.LastExecutionID || pending[e.ID] {
+				executions = append(executions, e)
+			}
+		}
+		// executions are listed newest first, so a page of only
+		// already archived executions ends the export
+		if token == "" || len(page) == 0 || (floor > 0 && older) {
+			break
+		}
+		nextPage = token
+	}
+	sort.Slice(executions, func(i, j int) bool { return executions[i].ID < executions[j].ID })
+	return executions, nil
+}
+
+// archiveExecution writes an execution, its steps and their values
+func archiveExecution(base, auth string, formulaID int, e FormulaInstanceExecution, files map[string]*os.File, sizes map[string]int64, result *ArchiveResult) error {
+	details, err := GetFormulaExecutionDetails(base, auth, strconv.Itoa(e.ID))
+	if err != nil {
+		return err
+	}
+	// the records of an execution are encoded first and appended together,
+	// with the execution itself last
+	var values, steps, execution bytes.Buffer
+	var valueCount, stepCount int
+	for _, s := range details.StepExecutions {
+		for _, v := range s.StepExecutionValues {
+			err = json.NewEncoder(&values).Encode(ArchivedStepExecutionValue{
+				ExecutionID:        e.ID,
+				StepExecutionID:    s.ID,
+				StepName:           s.StepName,
+				StepExecutionValue: v,
+			})
+			if err != nil {
+				return err
+			}
+			valueCount++
+		}
+		s.StepExecutionValues = nil
+		err = json.NewEncoder(&steps).Encode(ArchivedStepExecution{ExecutionID: e.ID, StepExecution: s})
+		if err != nil {
+			return err
+		}
+		stepCount++
+	}
+	err = json.NewEncoder(&execution).Encode(ArchivedExecution{FormulaID: formulaID, FormulaInstanceExecution: e})
+	if err != nil {
+		return err
+	}
+	for _, record := range []struct {
+		name string
+		b    *bytes.Buffer
+	}{{ArchiveValuesFile, &values}, {ArchiveStepsFile, &steps}, {ArchiveExecutionsFile, &execution}} {
+		if record.b.Len() == 0 {
+			continue
+		}
+		n, err := files[record.name].Write(record.b.Bytes())
+		if err != nil {
+			return err
+		}
+		sizes[record.name] += int64(n)
+	}
+	result.Values += valueCount
+	result.Steps += stepCount
+	result.Executions++
+	return nil
+}
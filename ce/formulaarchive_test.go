@@ -0,0 +1,213 @@
+package ce
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestArchiveFormulaExecutions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	statuses := map[int]string{1: "success", 2: "failed", 3: "success", 4: "pending"}
+	var pages int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/formulas/7/instances":
+			w.Write([]byte(`[{"id": 70, "name": "archived"}]`))
+		case r.URL.Path == "/formulas/instances/70/executions":
+			pages++
+			// newest first, two per page
+			var ids []int
+			for id := range statuses {
+				ids = append(ids, id)
+			}
+			sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+			start, _ := strconv.Atoi(r.URL.Query().Get("nextPage"))
+			end := start + 2
+			if end < len(ids) {
+				w.Header().Set(NextPageTokenHeader, strconv.Itoa(end))
+			} else {
+				end = len(ids)
+			}
+			var page []FormulaInstanceExecution
+			for _, id := range ids[start:end] {
+				page = append(page, FormulaInstanceExecution{ID: id, Status: statuses[id]})
+			}
+			json.NewEncoder(w).Encode(page)
+		case strings.HasSuffix(r.URL.Path, "/steps"):
+			id := strings.Split(r.URL.Path, "/")[4]
+			fmt.Fprintf(w, `[{"id": %s1, "stepName": "a", "status": "success"}, {"id": %s2, "stepName": "b", "status": "success"}]`, id, id)
+		case strings.HasSuffix(r.URL.Path, "/values"):
+			w.Write([]byte(`[{"key": "x.request", "value": "{}"}]`))
+		case strings.HasPrefix(r.URL.Path, "/formulas/instances/executions/"):
+			id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/formulas/instances/executions/"))
+			json.NewEncoder(w).Encode(FormulaInstanceExecution{ID: id, Status: statuses[id]})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	opts := ArchiveOptions{FormulaIDs: []int{7}, PageSize: 2}
+	result, err := ArchiveFormulaExecutions(ts.URL, auth, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (ArchiveResult{Executions: 3, Steps: 6, Values: 6, Pending: 1}) {
+		t.Errorf("unexpected first run %+v", result)
+	}
+	checkpoint, err := ReadArchiveCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := checkpoint.Instances["70"]; p.LastExecutionID != 4 || len(p.Pending) != 1 || p.Pending[0] != 4 {
+		t.Errorf("unexpected checkpoint %+v", checkpoint)
+	}
+
+	// the pending execution finishes and a new one runs
+	mu.Lock()
+	statuses[4] = "success"
+	for id := 5; id <= 8; id++ {
+		statuses[id] = "success"
+	}
+	pages = 0
+	mu.Unlock()
+	result, err = ArchiveFormulaExecutions(ts.URL, auth, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (ArchiveResult{Executions: 5, Steps: 10, Values: 10}) {
+		t.Errorf("unexpected second run %+v", result)
+	}
+	if pages != 4 {
+		t.Errorf("expected paging to stop at archived executions, read %v pages", pages)
+	}
+
+	var ids []int
+	for _, line := range readLines(t, filepath.Join(dir, ArchiveExecutionsFile)) {
+		var e ArchivedExecution
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.FormulaID != 7 || e.FormulaInstanceID != 70 {
+			t.Errorf("unexpected execution %s", line)
+		}
+		ids = append(ids, e.ID)
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5 6 7 8]" {
+		t.Errorf("unexpected archived executions %v", ids)
+	}
+	var v ArchivedStepExecutionValue
+	values := readLines(t, filepath.Join(dir, ArchiveValuesFile))
+	if len(values) != 16 || json.Unmarshal([]byte(values[0]), &v) != nil || v.ExecutionID != 1 || v.StepExecutionID != 11 || v.StepName != "a" || v.Key != "x.request" {
+		t.Errorf("unexpected values %v", values)
+	}
+}
+
+func TestArchiveFormulaExecutionsResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	failing := 3
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/formulas/7/instances":
+			w.Write([]byte(`[{"id": 70, "name": "archived"}]`))
+		case r.URL.Path == "/formulas/instances/70/executions":
+			w.Write([]byte(`[{"id": 4, "status": "success"}, {"id": 3, "status": "success"}, {"id": 2, "status": "failed"}, {"id": 1, "status": "success"}]`))
+		case strings.HasSuffix(r.URL.Path, "/steps"):
+			w.Write([]byte(`[]`))
+		case strings.HasPrefix(r.URL.Path, "/formulas/instances/executions/"):
+			id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/formulas/instances/executions/"))
+			if id == failing {
+				w.WriteHeader(500)
+				return
+			}
+			json.NewEncoder(w).Encode(FormulaInstanceExecution{ID: id, Status: "success"})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	opts := ArchiveOptions{FormulaIDs: []int{7}}
+	_, err = ArchiveFormulaExecutions(ts.URL, auth, dir, opts)
+	if err == nil {
+		t.Fatal("expected the first run to fail on execution 3")
+	}
+	checkpoint, err := ReadArchiveCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := checkpoint.Instances["70"]; p.LastExecutionID != 2 {
+		t.Errorf("unexpected checkpoint after failure %+v", p)
+	}
+
+	// an interrupted write leaves records past the checkpoint
+	f, err := os.OpenFile(filepath.Join(dir, ArchiveExecutionsFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"formulaId": 7, "id": 3, "status": "succ`))
+	f.Close()
+
+	mu.Lock()
+	failing = 0
+	mu.Unlock()
+	result, err := ArchiveFormulaExecutions(ts.URL, auth, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Executions != 2 {
+		t.Errorf("unexpected rerun %+v", result)
+	}
+	var ids []int
+	for _, line := range readLines(t, filepath.Join(dir, ArchiveExecutionsFile)) {
+		var e ArchivedExecution
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.ID)
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4]" {
+		t.Errorf("expected each execution archived once, got %v", ids)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines
+}
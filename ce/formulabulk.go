@@ -0,0 +1,193 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBulkConcurrency is the number of concurrent requests made by bulk execution operations
+const DefaultBulkConcurrency = 4
+
+// ExecutionFilter selects Formula Instance Executions for bulk operations
+type ExecutionFilter struct {
+	FormulaInstanceIDs []string
+	// Statuses to include; empty includes every status
+	Statuses []string
+	// From and To bound the execution's created date; zero values are unbounded
+	From time.Time
+	To   time.Time
+	// OlderThan only includes executions created at least this long ago
+	OlderThan time.Duration
+}
+
+// Matches returns true if the execution passes the status and time window filters
+func (f ExecutionFilter) Matches(e FormulaInstanceExecution) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, s := range f.Statuses {
+			if s == e.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.From.IsZero() && e.CreateDate.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.CreateDate.After(f.To) {
+		return false
+	}
+	if f.OlderThan > 0 && time.Since(e.CreateDate) < f.OlderThan {
+		return false
+	}
+	return true
+}
+
+// ExecutionActionResult is the outcome of retrying or cancelling one execution
+type ExecutionActionResult struct {
+	ExecutionID       int    `json:"executionId"`
+	FormulaInstanceID int    `json:"formulaInstanceId"`
+	Status            int    `json:"status"`
+	Success           bool   `json:"success"`
+	Error             string `json:"error,omitempty"`
+}
+
+// FindFormulaExecutions returns the executions of the filter's Formula
+// Instances that match it, reading every page of executions created since From
+func FindFormulaExecutions(base, auth string, filter ExecutionFilter) ([]FormulaInstanceExecution, error) {
+	var matched []FormulaInstanceExecution
+	for _, id := range filter.FormulaInstanceIDs {
+		instanceID, _ := strconv.Atoi(id)
+		err := EachFormulaInstanceExecutionsPage(base, auth, id, 0, func(page []FormulaInstanceExecution) bool {
+			newer := false
+			for _, e := range page {
+				if e.FormulaInstanceID == 0 {
+					e.FormulaInstanceID = instanceID
+				}
+				if filter.Matches(e) {
+					matched = append(matched, e)
+				}
+				if filter.From.IsZero() || !e.CreateDate.Before(filter.From) {
+					newer = true
+				}
+			}
+			// executions are listed newest first
+			return newer
+		})
+		if err != nil {
+			return matched, fmt.Errorf("formula instance %s: %s", id, err)
+		}
+	}
+	return matched, nil
+}
+
+// RetryFormulaExecutions retries every failed execution matching the filter,
+// with at most concurrency requests in flight, and reports the result of each
+// retry; only failed executions can be retried, so Statuses defaults to failed
+func RetryFormulaExecutions(base, auth string, filter ExecutionFilter, concurrency int, debug bool) ([]ExecutionActionResult, error) {
+	if len(filter.Statuses) == 0 {
+		filter.Statuses = []string{ExecutionFailed}
+	}
+	for _, s := range filter.Statuses {
+		if s != ExecutionFailed {
+			return nil, fmt.Errorf("only %s executions can be retried, not %s", ExecutionFailed, s)
+		}
+	}
+	executions, err := FindFormulaExecutions(base, auth, filter)
+	if err != nil {
+		return nil, err
+	}
+	if debug {
+		log.Printf("Retrying %v executions", len(executions))
+	}
+	return forEachExecution(executions, concurrency, func(e FormulaInstanceExecution) ([]byte, int, string, error) {
+		return RetryFormulaExecution(base, auth, strconv.Itoa(e.ID))
+	}), nil
+}
+
+// forEachExecution applies an action to executions with bounded concurrency,
+// returning results in the order of executions
+func forEachExecution(executions []FormulaInstanceExecution, concurrency int, action func(FormulaInstanceExecution) ([]byte, int, string, error)) []ExecutionActionResult {
+	if concurrency <= 0 {
+		concurrency = DefaultBulkConcurrency
+	}
+	results := make([]ExecutionActionResult, len(executions))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				e := executions[i]
+				r := ExecutionActionResult{ExecutionID: e.ID, FormulaInstanceID: e.FormulaInstanceID}
+				bodybytes, status, _, err := action(e)
+				r.Status = status
+				switch {
+				case err != nil:
+					r.Error = err.Error()
+				case status != 200:
+					r.Error = fmt.Sprintf("Status code %v: %s", status, bodybytes)
+				default:
+					r.Success = true
+				}
+				results[i] = r
+			}
+		}()
+	}
+	for i := range executions {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+// CancelFormulaExecutions cancels in-flight executions across the instances of
+// a Formula, with at most concurrency requests in flight; instances are
+// discovered from the Formula unless the filter names them, and only
+// unfinished executions are cancelled unless the filter sets Statuses
+func CancelFormulaExecutions(base, auth string, formulaID string, filter ExecutionFilter, concurrency int, debug bool) ([]ExecutionActionResult, error) {
+	if len(filter.FormulaInstanceIDs) == 0 {
+		bodybytes, status, _, err := GetFormulaInstances(base, auth, formulaID)
+		if err != nil {
+			return nil, err
+		}
+		if status != 200 {
+			return nil, fmt.Errorf("Unable to list instances of formula %s, status code %v", formulaID, status)
+		}
+		var instances []FormulaInstance
+		err = json.Unmarshal(bodybytes, &instances)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range instances {
+			filter.FormulaInstanceIDs = append(filter.FormulaInstanceIDs, strconv.Itoa(fi.ID))
+		}
+	}
+
+	executions, err := FindFormulaExecutions(base, auth, filter)
+	if err != nil {
+		return nil, err
+	}
+	var running []FormulaInstanceExecution
+	for _, e := range executions {
+		if len(filter.Statuses) == 0 && ExecutionFinished(e.Status) {
+			continue
+		}
+		running = append(running, e)
+	}
+	if debug {
+		log.Printf("Cancelling %v executions across %v instances of formula %s", len(running), len(filter.FormulaInstanceIDs), formulaID)
+	}
+	return forEachExecution(running, concurrency, func(e FormulaInstanceExecution) ([]byte, int, string, error) {
+		return CancelFormulaExecution(base, auth, strconv.Itoa(e.ID))
+	}), nil
+}
@@ -0,0 +1,153 @@
+package ce
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryFormulaExecutions(t *testing.T) {
+	var mu sync.Mutex
+	var retried []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/formulas/instances/5/executions":
+			w.Write([]byte(`[
+				{"id":1,"status":"failed","createdDate":"2018-01-01T00:00:00Z"},
+				{"id":2,"status":"success","createdDate":"2018-01-01T01:00:00Z"},
+				{"id":3,"status":"failed","createdDate":"2018-01-01T02:00:00Z"},
+				{"id":4,"status":"failed","createdDate":"2018-01-02T00:00:00Z"}
+			]`))
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/retries"):
+			mu.Lock()
+			retried = append(retried, r.URL.Path)
+			mu.Unlock()
+			if r.URL.Path == "/formulas/instances/executions/3/retries" {
+				w.WriteHeader(400)
+			}
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	filter := ExecutionFilter{
+		FormulaInstanceIDs: []string{"5"},
+		Statuses:           []string{ExecutionFailed},
+		From:               time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:                 time.Date(2018, 1, 1, 23, 0, 0, 0, time.UTC),
+	}
+	results, err := RetryFormulaExecutions(ts.URL, auth, filter, 2, false)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	sort.Strings(retried)
+	if strings.Join(retried, ",") != "/formulas/instances/executions/1/retries,/formulas/instances/executions/3/retries" {
+		t.Errorf("unexpected retries %v", retried)
+	}
+	if len(results) != 2 || !results[0].Success || results[0].FormulaInstanceID != 5 || results[1].Success || results[1].Status != 400 {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	// without statuses only failed executions are retried
+	retried = nil
+	results, err = RetryFormulaExecutions(ts.URL, auth, ExecutionFilter{FormulaInstanceIDs: []string{"5"}}, 2, false)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(results) != 3 || len(retried) != 3 {
+		t.Errorf("unexpected results %+v", results)
+	}
+	for _, r := range results {
+		if r.ExecutionID == 2 {
+			t.Errorf("retried successful execution %+v", r)
+		}
+	}
+
+	retried = nil
+	_, err = RetryFormulaExecutions(ts.URL, auth, ExecutionFilter{FormulaInstanceIDs: []string{"5"}, Statuses: []string{ExecutionSuccess}}, 2, false)
+	if err == nil || len(retried) != 0 {
+		t.Errorf("expected error retrying successful executions, got %v %v", err, retried)
+	}
+}
+
+func TestCancelFormulaExecutions(t *testing.T) {
+	var mu sync.Mutex
+	var cancelled []string
+	old := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	recent := time.Now().UTC().Format(time.RFC3339)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/formulas/10/instances":
+			w.Write([]byte(`[{"id":5},{"id":6}]`))
+		case r.URL.Path == "/formulas/instances/5/executions":
+			w.Write([]byte(`[
+				{"id":1,"status":"pending","createdDate":"` + old + `"},
+				{"id":2,"status":"success","createdDate":"` + old + `"}
+			]`))
+		case r.URL.Path == "/formulas/instances/6/executions":
+			w.Write([]byte(`[
+				{"id":3,"status":"started","createdDate":"` + old + `"},
+				{"id":4,"status":"pending","createdDate":"` + recent + `"}
+			]`))
+		case r.Method == "PATCH":
+			mu.Lock()
+			cancelled = append(cancelled, r.URL.Path)
+			mu.Unlock()
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	results, err := CancelFormulaExecutions(ts.URL, auth, "10", ExecutionFilter{OlderThan: 10 * time.Minute}, 0, false)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	sort.Strings(cancelled)
+	if strings.Join(cancelled, ",") != "/formulas/instances/executions/1,/formulas/instances/executions/3" {
+		t.Errorf("unexpected cancellations %v", cancelled)
+	}
+	if len(results) != 2 || !results[0].Success || results[1].FormulaInstanceID != 6 {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestFindFormulaExecutionsPaged(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var executions []FormulaInstanceExecution
+	for i := 7; i > 0; i-- {
+		status := ExecutionSuccess
+		if i%2 == 1 {
+			status = ExecutionFailed
+		}
+		executions = append(executions, FormulaInstanceExecution{ID: i, Status: status, CreateDate: start.Add(time.Duration(i) * time.Hour)})
+	}
+	ts := pagedExecutionsServer(t, "5", executions, 2)
+	defer ts.Close()
+
+	found, err := FindFormulaExecutions(ts.URL, auth, ExecutionFilter{FormulaInstanceIDs: []string{"5"}, Statuses: []string{ExecutionFailed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range found {
+		if e.FormulaInstanceID != 5 {
+			t.Errorf("expected formula instance 5, got %+v", e)
+		}
+		ids = append(ids, strconv.Itoa(e.ID))
+	}
+	if strings.Join(ids, ",") != "7,5,3,1" {
+		t.Errorf("expected failed executions from every page, got %v", ids)
+	}
+
+	found, err = FindFormulaExecutions(ts.URL, auth, ExecutionFilter{FormulaInstanceIDs: []string{"5"}, From: start.Add(4 * time.Hour)})
+	if err != nil || len(found) != 4 || found[3].ID != 4 {
+		t.Errorf("unexpected executions since From %+v %v", found, err)
+	}
+}
@@ -0,0 +1,502 @@
+package ce
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FormulaBundleVersion is the version of the bundle layout written by WriteFormulaBundle
+	FormulaBundleVersion = 1
+
+	bundleManifestFile      = "bundle.json"
+	bundleFormulaFile       = "formula.json"
+	bundleResourcesDir      = "resources"
+	bundleTransformationDir = "transformations"
+)
+
+// FormulaBundle is a Formula with the common resources and transformations it
+// depends on, and the configuration its instances need
+type FormulaBundle struct {
+	Version     int       `json:"version"`
+	CreatedDate time.Time `json:"createdDate"`
+	Formula     Formula   `json:"formula"`
+	// Resources are common resource definitions, keyed by name
+	Resources       map[string]json.RawMessage `json:"resources"`
+	Transformations []BundleTransformation     `json:"transformations"`
+	// Configuration is the Formula's configuration schema, the keys each
+	// Formula Instance must provide
+	Configuration []Configuration `json:"configuration"`
+}
+
+// BundleTransformation is the transformation of a common resource for an
+// Element; in a bundle's files the transformation is stored on its own
+type BundleTransformation struct {
+	ElementKey     string          `json:"elementKey"`
+	ObjectName     string          `json:"objectName"`
+	Transformation json.RawMessage `json:"transformation,omitempty"`
+}
+
+// bundleManifest is the bundle.json file listing the contents of a bundle
+type bundleManifest struct {
+	Version         int                    `json:"version"`
+	CreatedDate     time.Time              `json:"createdDate"`
+	Formula         string                 `json:"formula"`
+	Resources       []string               `json:"resources"`
+	Transformations []BundleTransformation `json:"transformations"`
+	Configuration   []Configuration        `json:"configuration"`
+}
+
+// ExportFormulaBundle gathers a Formula and the common resources and
+// transformations it references; transformations are collected for the given
+// element keys and for the Elements of the Formula's existing instances
+func ExportFormulaBundle(base, auth string, formulaID string, elementKeys []string, debug bool) (FormulaBundle, error) {
+	bundle := FormulaBundle{
+		Version:     FormulaBundleVersion,
+		CreatedDate: time.Now().UTC(),
+		Resources:   make(map[string]json.RawMessage),
+	}
+
+	bodybytes, status, _, err := FormulaDetailsAsBytes(formulaID, base, auth)
+	if err != nil {
+		return bundle, err
+	}
+	if status != 200 {
+		return bundle, fmt.Errorf("Unable to retrieve formula %s, status code %v", formulaID, status)
+	}
+	err = json.Unmarshal(bodybytes, &bundle.Formula)
+	if err != nil {
+		return bundle, err
+	}
+	bundle.Configuration = bundle.Formula.Configuration
+	formulabytes, err := json.Marshal(bundle.Formula)
+	if err != nil {
+		return bundle, err
+	}
+
+	// common resources referenced by name
+	bodybytes, status, _, err = ResourcesList(base, auth)
+	if err != nil {
+		return bundle, err
+	}
+	if status != 200 {
+		return bundle, fmt.Errorf("Unable to list resources, status code %v", status)
+	}
+	var resources []CommonResource
+	err = json.Unmarshal(bodybytes, &resources)
+	if err != nil {
+		return bundle, err
+	}
+	for _, r := range resources {
+		if !referencesResource(string(formulabytes), r.Name) {
+			continue
+		}
+		if debug {
+			log.Printf("Formula references resource %s", r.Name)
+		}
+		bodybytes, status, _, err = GetResourceDefinition(base, auth, r.Name, false)
+		if err != nil {
+			return bundle, err
+		}
+		if status != 200 {
+			return bundle, fmt.Errorf("Unable to retrieve resource %s, status code %v", r.Name, status)
+		}
+		bundle.Resources[r.Name] = json.RawMessage(bodybytes)
+	}
+
+	// transformations of those resources, for the Elements in use
+	keys := make(map[string]bool)
+	for _, k := range elementKeys {
+		keys[k] = true
+	}
+	instanceKeys, err := formulaElementKeys(base, auth, bundle.Formula)
+	if err != nil {
+		return bundle, err
+	}
+	for _, k := range instanceKeys {
+		keys[k] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+	for _, key := range sortedKeys {
+		bodybytes, status, _, err = GetTransformationsPerElement(base, auth, key)
+		if err != nil {
+			return bundle, err
+		}
+		if status != 200 {
+			return bundle, fmt.Errorf("Unable to retrieve transformations for %s, status code %v", key, status)
+		}
+		var transformations map[string]json.RawMessage
+		err = json.Unmarshal(bodybytes, &transformations)
+		if err != nil {
+			return bundle, err
+		}
+		for name, tx := range transformations {
+			if _, ok := bundle.Resources[name]; !ok {
+				continue
+			}
+			bundle.Transformations = append(bundle.Transformations, BundleTransformation{
+				ElementKey:     key,
+				ObjectName:     name,
+				Transformation: tx,
+			})
+		}
+	}
+	sort.Slice(bundle.Transformations, func(i, j int) bool {
+		a, b := bundle.Transformations[i], bundle.Transformations[j]
+		if a.ElementKey != b.ElementKey {
+			return a.ElementKey < b.ElementKey
+		}
+		return a.ObjectName < b.ObjectName
+	})
+
+	return bundle, nil
+}
+
+// referencesResource looks for a resource name used as a path segment
+// (/hubs/crm/contacts) or as a whole JSON string value
+func referencesResource(formulajson, name string) bool {
+	for _, form := range []string{"/" + name + `"`, "/" + name + "/", "/" + name + "?", `"` + name + `"`} {
+		if strings.Contains(formulajson, form) {
+			return true
+		}
+	}
+	return false
+}
+
+// formulaElementKeys returns the keys of the Elements configured on the
+// Formula's instances through its elementInstance configuration
+func formulaElementKeys(base, auth string, f Formula) ([]string, error) {
+	instances, err := GetInstancesOfFormula(f.ID, base, auth)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for _, fi := range instances {
+		values := fi.ConfigurationValues()
+		for _, c := range f.Configuration {
+			if c.Type != "elementInstance" || values[c.Key] == "" || seen[values[c.Key]] {
+				continue
+			}
+			seen[values[c.Key]] = true
+			bodybytes, status, _, err := GetInstanceInfo(base, auth, values[c.Key])
+			if err != nil || status != 200 {
+				// the instance may have been deleted; skip it
+				continue
+			}
+			var instance ElementInstance
+			if json.Unmarshal(bodybytes, &instance) == nil && instance.Element.Key != "" {
+				keys = append(keys, instance.Element.Key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// bundleNamePattern is the allow-list for resource, element and object names
+// used as file names in a bundle
+var bundleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// bundlePath joins names into a bundle file path, rejecting names that are
+// not plain file names
+func bundlePath(dir string, names ...string) (string, error) {
+	for _, name := range names {
+		if !bundleNamePattern.MatchString(name) {
+			return "", fmt.Errorf("%q cannot be used as a bundle file name", name)
+		}
+	}
+	return path.Join(append([]string{dir}, names...)...) + ".json", nil
+}
+
+// files returns the bundle's contents keyed by slash-separated path
+func (b FormulaBundle) files() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	manifest := bundleManifest{
+		Version:       b.Version,
+		CreatedDate:   b.CreatedDate,
+		Formula:       b.Formula.Name,
+		Configuration: b.Configuration,
+	}
+	for name, definition := range b.Resources {
+		p, err := bundlePath(bundleResourcesDir, name)
+		if err != nil {
+			return files, err
+		}
+		manifest.Resources = append(manifest.Resources, name)
+		files[p] = definition
+	}
+	sort.Strings(manifest.Resources)
+	for _, t := range b.Transformations {
+		p, err := bundlePath(bundleTransformationDir, t.ElementKey, t.ObjectName)
+		if err != nil {
+			return files, err
+		}
+		files[p] = t.Transformation
+		manifest.Transformations = append(manifest.Transformations, BundleTransformation{ElementKey: t.ElementKey, ObjectName: t.ObjectName})
+	}
+	var err error
+	files[bundleManifestFile], err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return files, err
+	}
+	files[bundleFormulaFile], err = json.MarshalIndent(b.Formula, "", "  ")
+	if err != nil {
+		return files, err
+	}
+	return files, nil
+}
+
+// bundleFromFiles reconstructs a bundle from its file contents
+func bundleFromFiles(files map[string][]byte) (FormulaBundle, error) {
+	var bundle FormulaBundle
+	var manifest bundleManifest
+	manifestbytes, ok := files[bundleManifestFile]
+	if !ok {
+		return bundle, fmt.Errorf("bundle is missing %s", bundleManifestFile)
+	}
+	err := json.Unmarshal(manifestbytes, &manifest)
+	if err != nil {
+		return bundle, err
+	}
+	if manifest.Version > FormulaBundleVersion {
+		return bundle, fmt.Errorf("bundle version %v is newer than supported version %v", manifest.Version, FormulaBundleVersion)
+	}
+	bundle.Version = manifest.Version
+	bundle.CreatedDate = manifest.CreatedDate
+	bundle.Configuration = manifest.Configuration
+	err = json.Unmarshal(files[bundleFormulaFile], &bundle.Formula)
+	if err != nil {
+		return bundle, fmt.Errorf("bundle %s: %s", bundleFormulaFile, err)
+	}
+	bundle.Resources = make(map[string]json.RawMessage)
+	for _, name := range manifest.Resources {
+		p, err := bundlePath(bundleResourcesDir, name)
+		if err != nil {
+			return bundle, err
+		}
+		definition, ok := files[p]
+		if !ok {
+			return bundle, fmt.Errorf("bundle is missing resource %s", name)
+		}
+		bundle.Resources[name] = json.RawMessage(definition)
+	}
+	for _, t := range manifest.Transformations {
+		p, err := bundlePath(bundleTransformationDir, t.ElementKey, t.ObjectName)
+		if err != nil {
+			return bundle, err
+		}
+		tx, ok := files[p]
+		if !ok {
+			return bundle, fmt.Errorf("bundle is missing transformation %s/%s", t.ElementKey, t.ObjectName)
+		}
+		t.Transformation = json.RawMessage(tx)
+		bundle.Transformations = append(bundle.Transformations, t)
+	}
+	return bundle, nil
+}
+
+// WriteFormulaBundle writes a bundle to a directory
+func WriteFormulaBundle(dir string, bundle FormulaBundle) error {
+	files, err := bundle.files()
+	if err != nil {
+		return err
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(p, contents, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFormulaBundle reads a bundle written by WriteFormulaBundle
+func ReadFormulaBundle(dir string) (FormulaBundle, error) {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)], err = ioutil.ReadFile(p)
+		return err
+	})
+	if err != nil {
+		return FormulaBundle{}, err
+	}
+	return bundleFromFiles(files)
+}
+
+// WriteFormulaBundleArchive writes a bundle as a gzipped tar archive
+func WriteFormulaBundleArchive(w io.Writer, bundle FormulaBundle) error {
+	files, err := bundle.files()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: bundle.CreatedDate,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(files[name])
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadFormulaBundleArchive reads a bundle written by WriteFormulaBundleArchive
+func ReadFormulaBundleArchive(r io.Reader) (FormulaBundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return FormulaBundle{}, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return FormulaBundle{}, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		files[header.Name], err = ioutil.ReadAll(tr)
+		if err != nil {
+			return FormulaBundle{}, err
+		}
+	}
+	return bundleFromFiles(files)
+}
+
+// Bundle import actions
+const (
+	BundleCreated = "created"
+	BundleUpdated = "updated"
+)
+
+// BundleImportResult is the outcome of applying one part of a bundle
+type BundleImportResult struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportFormulaBundle applies a bundle in dependency order: common resources,
+// then their transformations, then the Formula. Resources and transformations
+// that already exist in the account are replaced. It stops at the first
+// failure and returns the results so far.
+func ImportFormulaBundle(base, auth string, bundle FormulaBundle, debug bool) ([]BundleImportResult, error) {
+	var results []BundleImportResult
+	record := func(kind, name, action string, status int, err error) error {
+		r := BundleImportResult{Kind: kind, Name: name, Action: action, Status: status}
+		if err == nil && status != 200 {
+			err = fmt.Errorf("Unable to import %s %s, status code %v", kind, name, status)
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		if debug {
+			log.Printf("%s %s: %v %s", kind, name, status, r.Error)
+		}
+		results = append(results, r)
+		return err
+	}
+
+	names := make([]string, 0, len(bundle.Resources))
+	for name := range bundle.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		url := fmt.Sprintf("%s%s", base, fmt.Sprintf(CommonResourceDefinitionsFormatURI, name))
+		action, status, err := upsertBundlePart(url, auth, bundle.Resources[name])
+		if err = record("resource", name, action, status, err); err != nil {
+			return results, err
+		}
+	}
+
+	for _, t := range bundle.Transformations {
+		url := fmt.Sprintf("%s%s", base, fmt.Sprintf(ElementTransformationURIFormat, t.ElementKey, t.ObjectName))
+		action, status, err := upsertBundlePart(url, auth, t.Transformation)
+		if err = record("transformation", t.ElementKey+"/"+t.ObjectName, action, status, err); err != nil {
+			return results, err
+		}
+	}
+
+	bodybytes, status, _, err := ImportFormula(base, auth, StripFormulaIDs(bundle.Formula))
+	if err = record("formula", bundle.Formula.Name, BundleCreated, status, err); err != nil {
+		return results, err
+	}
+	var created Formula
+	if json.Unmarshal(bodybytes, &created) == nil && created.ID != 0 {
+		results[len(results)-1].Name = bundle.Formula.Name + " (" + strconv.Itoa(created.ID) + ")"
+	}
+	return results, nil
+}
+
+// upsertBundlePart creates the definition at url with a POST, or replaces it
+// with a PUT when it already exists
+func upsertBundlePart(url, auth string, definition []byte) (string, int, error) {
+	_, status, _, err := Execute("GET", url, auth)
+	if err != nil {
+		return "", status, err
+	}
+	switch status {
+	case 200:
+		_, status, _, err = ExecuteWithBody("PUT", url, auth, definition)
+		return BundleUpdated, status, err
+	case 404:
+		_, status, _, err = ExecuteWithBody("POST", url, auth, definition)
+		return BundleCreated, status, err
+	}
+	return "", status, fmt.Errorf("Unable to check for an existing definition, status code %v", status)
+}
@@ -0,0 +1,188 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testBundle = FormulaBundle{
+	Version:     FormulaBundleVersion,
+	CreatedDate: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	Formula: Formula{
+		ID:    19547,
+		Name:  "sync contacts",
+		Steps: []Step{{ID: 3, Name: "get", Type: "elementRequest", Properties: map[string]interface{}{"api": "/hubs/crm/myContact"}}},
+	},
+	Resources: map[string]json.RawMessage{
+		"myContact": json.RawMessage(`{"fields":[{"type":"string","path":"email"}]}`),
+	},
+	Transformations: []BundleTransformation{
+		{ElementKey: "sfdc", ObjectName: "myContact", Transformation: json.RawMessage(`{"vendorName":"Contact"}`)},
+	},
+	Configuration: []Configuration{{Key: "crm", Type: "elementInstance", Required: true}},
+}
+
+func TestFormulaBundleRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = WriteFormulaBundle(dir, testBundle)
+	if err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	fromDir, err := ReadFormulaBundle(dir)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteFormulaBundleArchive(&buf, testBundle)
+	if err != nil {
+		t.Fatalf("write archive: %s", err)
+	}
+	fromArchive, err := ReadFormulaBundleArchive(&buf)
+	if err != nil {
+		t.Fatalf("read archive: %s", err)
+	}
+
+	for _, b := range []FormulaBundle{fromDir, fromArchive} {
+		if b.Formula.Name != testBundle.Formula.Name || len(b.Formula.Steps) != 1 {
+			t.Errorf("unexpected formula %+v", b.Formula)
+		}
+		if !reflect.DeepEqual(b.Configuration, testBundle.Configuration) || !b.CreatedDate.Equal(testBundle.CreatedDate) {
+			t.Errorf("unexpected manifest %+v", b)
+		}
+		if string(b.Resources["myContact"]) != string(testBundle.Resources["myContact"]) {
+			t.Errorf("unexpected resources %s", b.Resources)
+		}
+		if len(b.Transformations) != 1 || string(b.Transformations[0].Transformation) != `{"vendorName":"Contact"}` {
+			t.Errorf("unexpected transformations %+v", b.Transformations)
+		}
+	}
+}
+
+func TestFormulaBundleJSON(t *testing.T) {
+	b, err := json.Marshal(testBundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	json.Unmarshal(b, &fields)
+	for _, key := range []string{"version", "createdDate", "formula", "resources", "transformations", "configuration"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("missing %s in %s", key, b)
+		}
+	}
+	var decoded FormulaBundle
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Transformations) != 1 || string(decoded.Transformations[0].Transformation) != `{"vendorName":"Contact"}` {
+		t.Errorf("unexpected transformations %+v", decoded.Transformations)
+	}
+}
+
+func TestImportFormulaBundle(t *testing.T) {
+	var requests []string
+	var imported Formula
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path == FormulasURI {
+			json.NewDecoder(r.Body).Decode(&imported)
+			fmt.Fprint(w, `{"id":20000}`)
+			return
+		}
+		// the resource already exists, the transformation doesn't
+		if r.Method == "GET" && r.URL.Path != "/organizations/objects/myContact/definitions" {
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+
+	results, err := ImportFormulaBundle(ts.URL, auth, testBundle, false)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	expected := []string{
+		"GET /organizations/objects/myContact/definitions",
+		"PUT /organizations/objects/myContact/definitions",
+		"GET /organizations/elements/sfdc/transformations/myContact",
+		"POST /organizations/elements/sfdc/transformations/myContact",
+		"POST /formulas",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if len(results) != 3 || results[0].Action != BundleUpdated || results[1].Action != BundleCreated || results[2].Name != "sync contacts (20000)" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if imported.ID != 0 || imported.Steps[0].ID != 0 {
+		t.Errorf("expected IDs stripped, got %+v", imported)
+	}
+}
+
+func TestExportFormulaBundle(t *testing.T) {
+	responses := map[string]string{
+		"/formulas/19547":   `{"id":19547,"name":"sync contacts","steps":[{"name":"get","type":"elementRequest","properties":{"api":"/hubs/crm/myContact"}}],"configuration":[{"key":"crm","type":"elementInstance","required":true}]}`,
+		"/common-resources": `[{"name":"myContact"},{"name":"myAccount"}]`,
+		"/organizations/objects/myContact/definitions":    `{"fields":[{"type":"string","path":"email"}]}`,
+		"/formulas/19547/instances":                       `[{"id":1,"configuration":{"crm":"123"}}]`,
+		"/instances/123":                                  `{"id":123,"element":{"key":"sfdc"}}`,
+		"/organizations/elements/sfdc/transformations":    `{"myContact":{"vendorName":"Contact"},"myAccount":{"vendorName":"Account"}}`,
+		"/organizations/elements/hubspot/transformations": `{}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	bundle, err := ExportFormulaBundle(ts.URL, auth, "19547", []string{"hubspot"}, false)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if bundle.Formula.Name != "sync contacts" || len(bundle.Configuration) != 1 {
+		t.Errorf("unexpected formula %+v", bundle.Formula)
+	}
+	if len(bundle.Resources) != 1 || string(bundle.Resources["myContact"]) != responses["/organizations/objects/myContact/definitions"] {
+		t.Errorf("unexpected resources %s", bundle.Resources)
+	}
+	if len(bundle.Transformations) != 1 || bundle.Transformations[0].ElementKey != "sfdc" || bundle.Transformations[0].ObjectName != "myContact" {
+		t.Errorf("unexpected transformations %+v", bundle.Transformations)
+	}
+}
+
+func TestWriteFormulaBundleUnsafeName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, b := range []FormulaBundle{
+		{Formula: testBundle.Formula, Resources: map[string]json.RawMessage{"../escape": json.RawMessage(`{}`)}},
+		{Formula: testBundle.Formula, Transformations: []BundleTransformation{{ElementKey: "/tmp", ObjectName: "myContact"}}},
+	} {
+		if err := WriteFormulaBundle(dir, b); err == nil {
+			t.Errorf("expected an error writing %+v", b)
+		}
+	}
+}
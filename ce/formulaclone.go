@@ -0,0 +1,193 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// CloneOptions controls CloneFormula; ConfigurationKeys maps configuration
+// keys of the source Formula to the keys used in the clone. Cloned Instances
+// are created inactive unless ActivateInstances is set, in which case they
+// keep the active state of their source.
+type CloneOptions struct {
+	ConfigurationKeys map[string]string
+	CloneInstances    bool
+	ActivateInstances bool
+	Debug             bool
+}
+
+// CloneResult is the outcome of CloneFormula, with the IDs of the cloned
+// Instances and the errors of those that could not be cloned, by source ID
+type CloneResult struct {
+	FormulaID      int            `json:"formulaId"`
+	Instances      []int          `json:"instances,omitempty"`
+	InstanceErrors map[int]string `json:"instanceErrors,omitempty"`
+}
+
+// CloneFormula copies a Formula under a new name, optionally renaming its
+// configuration keys, and optionally copies its Instances to the clone
+func CloneFormula(base, auth string, formulaID string, name string, opts CloneOptions) (CloneResult, error) {
+	var result CloneResult
+	if strings.TrimSpace(name) == "" {
+		return result, fmt.Errorf("a name is required for the cloned formula")
+	}
+	source, err := getFormula(base, auth, formulaID)
+	if err != nil {
+		return result, err
+	}
+
+	clone := StripFormulaIDs(source)
+	clone.Name = name
+	err = RenameConfigurationKeys(&clone, opts.ConfigurationKeys)
+	if err != nil {
+		return result, err
+	}
+	if opts.Debug {
+		log.Printf("Cloning formula %s as %s", source.Name, name)
+	}
+	bodybytes, status, _, err := ImportFormula(base, auth, clone)
+	if err != nil {
+		return result, err
+	}
+	if status != 200 {
+		return result, fmt.Errorf("Unable to create formula %s, status code %v: %s", name, status, bodybytes)
+	}
+	var created Formula
+	err = json.Unmarshal(bodybytes, &created)
+	if err != nil {
+		return result, err
+	}
+	result.FormulaID = created.ID
+	if !opts.CloneInstances {
+		return result, nil
+	}
+
+	instances, err := GetInstancesOfFormula(source.ID, base, auth)
+	if err != nil {
+		return result, fmt.Errorf("formula %v cloned as %v, unable to retrieve instances: %s", source.ID, created.ID, err)
+	}
+	for _, fi := range instances {
+		config := FormulaInstanceConfig{
+			Name:          fi.Name,
+			Active:        opts.ActivateInstances && fi.Active,
+			Configuration: renameConfigurationValues(fi.Configuration, opts.ConfigurationKeys),
+		}
+		if opts.Debug {
+			log.Printf("Cloning formula instance %v %s", fi.ID, fi.Name)
+		}
+		bodybytes, status, _, err := CreateFormulaInstance(base, auth, strconv.Itoa(created.ID), config)
+		if err == nil && status != 200 {
+			err = fmt.Errorf("Status code %v: %s", status, bodybytes)
+		}
+		var instance FormulaInstance
+		if err == nil {
+			err = json.Unmarshal(bodybytes, &instance)
+		}
+		if err != nil {
+			if result.InstanceErrors == nil {
+				result.InstanceErrors = make(map[int]string)
+			}
+			result.InstanceErrors[fi.ID] = err.Error()
+			continue
+		}
+		result.Instances = append(result.Instances, instance.ID)
+	}
+	return result, nil
+}
+
+// RenameConfigurationKeys renames configuration keys of a Formula, and the
+// ${config.<key>} and config['<key>'] references to them in step and trigger
+// properties and scripts, using the old to new key map; properties such as
+// myconfig.<key> or trigger.config.<key> are left alone
+func RenameConfigurationKeys(f *Formula, keys map[string]string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	declared := make(map[string]bool)
+	for _, c := range f.Configuration {
+		declared[c.Key] = true
+	}
+	for from, to := range keys {
+		if !declared[from] {
+			return fmt.Errorf("%s is not a configuration key of formula %s", from, f.Name)
+		}
+		if _, renamed := keys[to]; declared[to] && !renamed {
+			return fmt.Errorf("configuration key %s already exists in formula %s", to, f.Name)
+		}
+	}
+
+	configuration := make([]Configuration, len(f.Configuration))
+	for i, c := range f.Configuration {
+		if to, ok := keys[c.Key]; ok {
+			c.Key = to
+		}
+		configuration[i] = c
+	}
+	f.Configuration = configuration
+
+	steps := make([]Step, len(f.Steps))
+	for i, s := range f.Steps {
+		p, err := renameConfigReferences(s.Properties, keys)
+		if err != nil {
+			return fmt.Errorf("step %s: %s", s.Name, err)
+		}
+		s.Properties = p
+		steps[i] = s
+	}
+	f.Steps = steps
+	triggers := make([]Trigger, len(f.Triggers))
+	for i, t := range f.Triggers {
+		p, err := renameConfigReferences(t.Properties, keys)
+		if err != nil {
+			return fmt.Errorf("trigger %v: %s", i, err)
+		}
+		t.Properties = p
+		triggers[i] = t
+	}
+	f.Triggers = triggers
+	return nil
+}
+
+// renameConfigReferences rewrites configuration references in properties
+func renameConfigReferences(properties interface{}, keys map[string]string) (interface{}, error) {
+	if properties == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(properties)
+	if err != nil {
+		return nil, err
+	}
+	renamed := configReferencePattern.ReplaceAllStringFunc(string(b), func(ref string) string {
+		m := configReferencePattern.FindStringSubmatch(ref)
+		key := m[2] + m[3]
+		to, ok := keys[key]
+		if !ok {
+			return ref
+		}
+		prefix := m[1] + "config"
+		return prefix + strings.Replace(strings.TrimPrefix(ref, prefix), key, to, 1)
+	})
+	var p interface{}
+	err = json.Unmarshal([]byte(renamed), &p)
+	return p, err
+}
+
+// renameConfigurationValues returns a Formula Instance configuration with its
+// keys renamed, keeping the values as they are
+func renameConfigurationValues(configuration interface{}, keys map[string]string) interface{} {
+	values, ok := normalizeJSON(configuration).(map[string]interface{})
+	if !ok {
+		return configuration
+	}
+	renamed := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if to, ok := keys[k]; ok {
+			k = to
+		}
+		renamed[k] = v
+	}
+	return renamed
+}
@@ -0,0 +1,120 @@
+package ce
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCloneFormula(t *testing.T) {
+	var imported Formula
+	var created []FormulaInstanceConfig
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/formulas/5":
+			w.Write([]byte(`{"id": 5, "name": "sync", "active": true, "userId": 1, "accountId": 2,
+				"configuration": [{"id": 50, "key": "crm", "type": "elementInstance"}, {"id": 51, "key": "crmTarget", "type": "elementInstance"}],
+				"triggers": [{"id": 52, "type": "event", "onSuccess": ["get"], "properties": {"elementInstanceId": "${config.crm}"}}],
+				"steps": [
+					{"id": 53, "name": "get", "type": "elementRequest", "onSuccess": ["map"], "properties": {"elementInstanceId": "${config.crm}", "api": "/hubs/crm/contacts"}},
+					{"id": 54, "name": "map", "type": "script", "properties": {"body": "var a = config[\"crm\"], b = config.crmTarget;\ndone({a: a, b: config['crm']});"}}
+				]}`))
+		case r.Method == "POST" && r.URL.Path == "/formulas":
+			b, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(b, &imported)
+			imported.ID = 6
+			json.NewEncoder(w).Encode(imported)
+		case r.Method == "GET" && r.URL.Path == "/formulas/5/instances":
+			w.Write([]byte(`[{"id": 500, "name": "prod", "active": true, "configuration": {"crm": "1", "crmTarget": "2", "fields": {"email": true}, "ids": [1, 2], "dryRun": false}},
+				{"id": 501, "name": "bad", "active": false, "configuration": {"crm": "3"}}]`))
+		case r.Method == "POST" && r.URL.Path == "/formulas/6/instances":
+			var config FormulaInstanceConfig
+			b, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(b, &config)
+			created = append(created, config)
+			if config.Name == "bad" {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{"id": 600}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	result, err := CloneFormula(ts.URL, auth, "5", "sync copy", CloneOptions{
+		ConfigurationKeys: map[string]string{"crm": "source"},
+		CloneInstances:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FormulaID != 6 || len(result.Instances) != 1 || result.Instances[0] != 600 || result.InstanceErrors[501] == "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if imported.Name != "sync copy" || imported.UserID != 0 || imported.Steps[0].ID != 0 || imported.Configuration[0].ID != 0 {
+		t.Errorf("expected stripped, renamed formula, got %+v", imported)
+	}
+	if imported.Configuration[0].Key != "source" || imported.Configuration[1].Key != "crmTarget" {
+		t.Errorf("unexpected configuration %+v", imported.Configuration)
+	}
+	b, _ := json.Marshal(imported)
+	if strings.Contains(string(b), "config.crm}") || strings.Contains(string(b), `config[\"crm\"]`) || strings.Contains(string(b), "config['crm']") {
+		t.Errorf("references to crm remain: %s", b)
+	}
+	body := imported.Steps[1].Properties.(map[string]interface{})["body"]
+	if body != "var a = config[\"source\"], b = config.crmTarget;\ndone({a: a, b: config['source']});" {
+		t.Errorf("unexpected script %q", body)
+	}
+	if len(created) != 2 || created[0].Active || created[1].Active {
+		t.Fatalf("expected inactive instances, got %+v", created)
+	}
+	b, _ = json.Marshal(created[0].Configuration)
+	if string(b) != `{"crmTarget":"2","dryRun":false,"fields":{"email":true},"ids":[1,2],"source":"1"}` {
+		t.Errorf("unexpected instance configuration %s", b)
+	}
+
+	created = nil
+	_, err = CloneFormula(ts.URL, auth, "5", "sync copy", CloneOptions{CloneInstances: true, ActivateInstances: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || !created[0].Active || created[1].Active {
+		t.Errorf("expected instances to keep their active state, got %+v", created)
+	}
+}
+
+func TestRenameConfigurationKeys(t *testing.T) {
+	f := Formula{Name: "f", Configuration: []Configuration{{Key: "a"}, {Key: "b"}}}
+	if err := RenameConfigurationKeys(&f, map[string]string{"c": "d"}); err == nil {
+		t.Errorf("expected error for unknown key")
+	}
+	if err := RenameConfigurationKeys(&f, map[string]string{"a": "b"}); err == nil {
+		t.Errorf("expected error for existing key")
+	}
+	if err := RenameConfigurationKeys(&f, map[string]string{"a": "b", "b": "a"}); err != nil || f.Configuration[0].Key != "b" || f.Configuration[1].Key != "a" {
+		t.Errorf("expected swapped keys, got %+v %v", f.Configuration, err)
+	}
+}
+
+func TestRenameConfigurationKeysOnlyConfig(t *testing.T) {
+	body := "var myconfig = {a: 1}, trigger = {config: {a: 2}};\nvar x = myconfig.a + trigger.config.a + config.a;\nconfig['a'];"
+	f := Formula{
+		Name:          "f",
+		Configuration: []Configuration{{Key: "a"}},
+		Steps:         []Step{{Name: "s", Type: "script", Properties: map[string]interface{}{"body": body}}},
+	}
+	if err := RenameConfigurationKeys(&f, map[string]string{"a": "crm"}); err != nil {
+		t.Fatal(err)
+	}
+	got := f.Steps[0].Properties.(map[string]interface{})["body"]
+	want := "var myconfig = {a: 1}, trigger = {config: {a: 2}};\nvar x = myconfig.a + trigger.config.a + config.crm;\nconfig['crm'];"
+	if got != want {
+		t.Errorf("unexpected script\nwant %q\ngot  %q", want, got)
+	}
+}
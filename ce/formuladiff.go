@@ -0,0 +1,362 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Formula change kinds
+const (
+	ChangeFormula              = "formula"
+	ChangeStepAdded            = "step-added"
+	ChangeStepRemoved          = "step-removed"
+	ChangeStepRenamed          = "step-renamed"
+	ChangeStepChanged          = "step-changed"
+	ChangeEdgeAdded            = "edge-added"
+	ChangeEdgeRemoved          = "edge-removed"
+	ChangeTriggerAdded         = "trigger-added"
+	ChangeTriggerRemoved       = "trigger-removed"
+	ChangeTriggerChanged       = "trigger-changed"
+	ChangeConfigurationAdded   = "configuration-added"
+	ChangeConfigurationRemoved = "configuration-removed"
+	ChangeConfigurationChanged = "configuration-changed"
+)
+
+// FormulaChange is a single difference between two Formulas
+type FormulaChange struct {
+	Kind string `json:"kind"`
+	// Subject is the step name, trigger index or configuration key changed
+	Subject string `json:"subject,omitempty"`
+	// Path is the changed field, such as "type" or "properties.body"
+	Path string      `json:"path,omitempty"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// FormulaDiff is the list of changes from one Formula to another
+type FormulaDiff struct {
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Changes []FormulaChange `json:"changes"`
+}
+
+// Empty returns true if the Formulas are structurally equal
+func (d FormulaDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// String renders the diff for humans, one change per line
+func (d FormulaDiff) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", d.From, d.To)
+	if d.Empty() {
+		fmt.Fprintf(&b, "no changes\n")
+	}
+	for _, c := range d.Changes {
+		fmt.Fprintf(&b, "%s\n", c)
+	}
+	return b.String()
+}
+
+func (c FormulaChange) String() string {
+	switch c.Kind {
+	case ChangeStepAdded, ChangeTriggerAdded, ChangeConfigurationAdded, ChangeEdgeAdded:
+		return fmt.Sprintf("+ %s %s%s", c.Kind, c.Subject, pathSuffix(c.Path, c.New))
+	case ChangeStepRemoved, ChangeTriggerRemoved, ChangeConfigurationRemoved, ChangeEdgeRemoved:
+		return fmt.Sprintf("- %s %s%s", c.Kind, c.Subject, pathSuffix(c.Path, c.Old))
+	case ChangeStepRenamed:
+		return fmt.Sprintf("R %s %v -> %v", c.Kind, c.Old, c.New)
+	}
+	if c.Subject == "" {
+		return fmt.Sprintf("~ %s %s: %s -> %s", c.Kind, c.Path, diffValue(c.Old), diffValue(c.New))
+	}
+	return fmt.Sprintf("~ %s %s %s: %s -> %s", c.Kind, c.Subject, c.Path, diffValue(c.Old), diffValue(c.New))
+}
+
+func pathSuffix(path string, v interface{}) string {
+	if path == "" {
+		return ""
+	}
+	return fmt.Sprintf(" %s: %s", path, diffValue(v))
+}
+
+func diffValue(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// DiffFormulas compares two Formulas, such as a live Formula and one read from a
+// file, or the same Formula in two environments; IDs, user and account are
+// ignored since they differ between environments
+func DiffFormulas(a, b Formula) FormulaDiff {
+	d := FormulaDiff{From: a.Name, To: b.Name}
+	add := func(c FormulaChange) { d.Changes = append(d.Changes, c) }
+
+	// formula fields
+	fields := []struct {
+		path     string
+		old, new interface{}
+	}{
+		{"name", a.Name, b.Name},
+		{"description", a.Description, b.Description},
+		{"active", a.Active, b.Active},
+		{"api", a.API, b.API},
+		{"method", a.Method, b.Method},
+		{"uri", a.URI, b.URI},
+		{"engine", a.Engine, b.Engine},
+		{"debugLoggingEnabled", a.DebugLoggingEnabled, b.DebugLoggingEnabled},
+		{"singleThreaded", a.SingleThreaded, b.SingleThreaded},
+	}
+	for _, f := range fields {
+		if f.old != f.new {
+			add(FormulaChange{Kind: ChangeFormula, Path: f.path, Old: f.old, New: f.new})
+		}
+	}
+
+	// steps, matched by name, then renames by ID or identical content
+	aSteps := stepsByName(a.Steps)
+	bSteps := stepsByName(b.Steps)
+	var removed, added []Step
+	for _, s := range a.Steps {
+		if _, ok := bSteps[s.Name]; !ok {
+			removed = append(removed, s)
+		}
+	}
+	for _, s := range b.Steps {
+		if _, ok := aSteps[s.Name]; !ok {
+			added = append(added, s)
+		}
+	}
+	renames := make(map[string]string)
+	for _, r := range removed {
+		for i, n := range added {
+			if n.Name == "" || !sameStep(r, n) {
+				continue
+			}
+			renames[r.Name] = n.Name
+			add(FormulaChange{Kind: ChangeStepRenamed, Subject: n.Name, Old: r.Name, New: n.Name})
+			added = append(added[:i], added[i+1:]...)
+			break
+		}
+	}
+	for _, r := range removed {
+		if _, ok := renames[r.Name]; !ok {
+			add(FormulaChange{Kind: ChangeStepRemoved, Subject: r.Name, Path: "type", Old: r.Type})
+		}
+	}
+	for _, n := range added {
+		add(FormulaChange{Kind: ChangeStepAdded, Subject: n.Name, Path: "type", New: n.Type})
+	}
+	rename := func(names []string) []string {
+		out := make([]string, len(names))
+		for i, n := range names {
+			if r, ok := renames[n]; ok {
+				n = r
+			}
+			out[i] = n
+		}
+		return out
+	}
+	for _, s := range a.Steps {
+		name := s.Name
+		if r, ok := renames[name]; ok {
+			name = r
+		}
+		n, ok := bSteps[name]
+		if !ok {
+			continue
+		}
+		if s.Type != n.Type {
+			add(FormulaChange{Kind: ChangeStepChanged, Subject: name, Path: "type", Old: s.Type, New: n.Type})
+		}
+		for _, c := range diffJSON("properties", s.Properties, n.Properties) {
+			c.Kind = ChangeStepChanged
+			c.Subject = name
+			add(c)
+		}
+		for _, c := range diffEdges(name, "onSuccess", rename(s.OnSuccess), n.OnSuccess) {
+			add(c)
+		}
+		for _, c := range diffEdges(name, "onFailure", rename(s.OnFailure), n.OnFailure) {
+			add(c)
+		}
+	}
+
+	// triggers, matched by position
+	for i := 0; i < len(a.Triggers) || i < len(b.Triggers); i++ {
+		subject := "trigger " + strconv.Itoa(i)
+		switch {
+		case i >= len(b.Triggers):
+			add(FormulaChange{Kind: ChangeTriggerRemoved, Subject: subject, Path: "type", Old: a.Triggers[i].Type})
+			continue
+		case i >= len(a.Triggers):
+			add(FormulaChange{Kind: ChangeTriggerAdded, Subject: subject, Path: "type", New: b.Triggers[i].Type})
+			continue
+		}
+		at, bt := a.Triggers[i], b.Triggers[i]
+		if at.Type != bt.Type {
+			add(FormulaChange{Kind: ChangeTriggerChanged, Subject: subject, Path: "type", Old: at.Type, New: bt.Type})
+		}
+		if at.Async != bt.Async {
+			add(FormulaChange{Kind: ChangeTriggerChanged, Subject: subject, Path: "async", Old: at.Async, New: bt.Async})
+		}
+		for _, c := range diffJSON("properties", at.Properties, bt.Properties) {
+			c.Kind = ChangeTriggerChanged
+			c.Subject = subject
+			add(c)
+		}
+		for _, c := range diffEdges(subject, "onSuccess", rename(at.OnSuccess), bt.OnSuccess) {
+			add(c)
+		}
+		for _, c := range diffEdges(subject, "onFailure", rename(at.OnFailure), bt.OnFailure) {
+			add(c)
+		}
+	}
+
+	// configuration, matched by key
+	aConfig := make(map[string]Configuration)
+	for _, c := range a.Configuration {
+		aConfig[c.Key] = c
+	}
+	bConfig := make(map[string]Configuration)
+	for _, c := range b.Configuration {
+		bConfig[c.Key] = c
+	}
+	for _, c := range a.Configuration {
+		n, ok := bConfig[c.Key]
+		if !ok {
+			add(FormulaChange{Kind: ChangeConfigurationRemoved, Subject: c.Key, Path: "type", Old: c.Type})
+			continue
+		}
+		if c.Name != n.Name {
+			add(FormulaChange{Kind: ChangeConfigurationChanged, Subject: c.Key, Path: "name", Old: c.Name, New: n.Name})
+		}
+		if c.Type != n.Type {
+			add(FormulaChange{Kind: ChangeConfigurationChanged, Subject: c.Key, Path: "type", Old: c.Type, New: n.Type})
+		}
+		if c.Required != n.Required {
+			add(FormulaChange{Kind: ChangeConfigurationChanged, Subject: c.Key, Path: "required", Old: c.Required, New: n.Required})
+		}
+	}
+	for _, c := range b.Configuration {
+		if _, ok := aConfig[c.Key]; !ok {
+			add(FormulaChange{Kind: ChangeConfigurationAdded, Subject: c.Key, Path: "type", New: c.Type})
+		}
+	}
+
+	return d
+}
+
+func stepsByName(steps []Step) map[string]Step {
+	m := make(map[string]Step)
+	for _, s := range steps {
+		m[s.Name] = s
+	}
+	return m
+}
+
+// sameStep decides whether a removed and an added step are the same step
+// renamed: same non-zero ID, or same type and properties
+func sameStep(a, b Step) bool {
+	if a.ID != 0 && a.ID == b.ID {
+		return true
+	}
+	return a.Type == b.Type && len(diffJSON("", a.Properties, b.Properties)) == 0
+}
+
+func diffEdges(subject, edge string, old, new []string) []FormulaChange {
+	var changes []FormulaChange
+	oldSet := make(map[string]bool)
+	for _, n := range old {
+		oldSet[n] = true
+	}
+	newSet := make(map[string]bool)
+	for _, n := range new {
+		newSet[n] = true
+	}
+	for _, n := range old {
+		if !newSet[n] {
+			changes = append(changes, FormulaChange{Kind: ChangeEdgeRemoved, Subject: subject, Path: edge, Old: n})
+		}
+	}
+	for _, n := range new {
+		if !oldSet[n] {
+			changes = append(changes, FormulaChange{Kind: ChangeEdgeAdded, Subject: subject, Path: edge, New: n})
+		}
+	}
+	return changes
+}
+
+// normalizeJSON round-trips a value through JSON so typed structs and
+// decoded maps compare equal
+func normalizeJSON(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var n interface{}
+	if json.Unmarshal(b, &n) != nil {
+		return v
+	}
+	return n
+}
+
+// diffJSON deep-compares two JSON-compatible values, returning a change per
+// differing leaf with a dotted path
+func diffJSON(path string, old, new interface{}) []FormulaChange {
+	return diffNormalized(path, normalizeJSON(old), normalizeJSON(new))
+}
+
+func diffNormalized(path string, old, new interface{}) []FormulaChange {
+	om, oldIsMap := old.(map[string]interface{})
+	nm, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make(map[string]bool)
+		for k := range om {
+			keys[k] = true
+		}
+		for k := range nm {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		var changes []FormulaChange
+		for _, k := range sorted {
+			changes = append(changes, diffNormalized(joinPath(path, k), om[k], nm[k])...)
+		}
+		return changes
+	}
+	oa, oldIsArray := old.([]interface{})
+	na, newIsArray := new.([]interface{})
+	if oldIsArray && newIsArray && len(oa) == len(na) {
+		var changes []FormulaChange
+		for i := range oa {
+			changes = append(changes, diffNormalized(fmt.Sprintf("%s[%v]", path, i), oa[i], na[i])...)
+		}
+		return changes
+	}
+	if reflect.DeepEqual(old, new) {
+		return nil
+	}
+	return []FormulaChange{{Path: path, Old: old, New: new}}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,71 @@
+package ce
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffFormulas(t *testing.T) {
+	a := Formula{
+		Name:     "sync",
+		Active:   true,
+		Triggers: []Trigger{{Type: "event", OnSuccess: []string{"check"}}},
+		Steps: []Step{
+			{ID: 1, Name: "check", Type: "filter", OnSuccess: []string{"send"}, Properties: map[string]interface{}{"body": "done(true);"}},
+			{ID: 2, Name: "send", Type: "elementRequest", OnSuccess: []string{"notify"}, Properties: map[string]interface{}{"method": "POST", "api": "/hubs/crm/contacts"}},
+			{ID: 3, Name: "notify", Type: "notification"},
+		},
+		Configuration: []Configuration{{Key: "source", Type: "elementInstance", Required: true}},
+	}
+	var b Formula
+	copyJSON(t, a, &b)
+	b.Active = false
+	b.Steps[1].Name = "create contact"
+	b.Steps[0].OnSuccess = []string{"create contact"}
+	b.Steps[1].Properties = map[string]interface{}{"method": "PUT", "api": "/hubs/crm/contacts"}
+	b.Steps[2].OnFailure = []string{"check"}
+	b.Steps = append(b.Steps, Step{Name: "log", Type: "script"})
+	b.Triggers[0].Async = true
+	b.Configuration = append(b.Configuration, Configuration{Key: "target", Type: "elementInstance"})
+	b.Configuration[0].Required = false
+
+	d := DiffFormulas(a, b)
+	expected := []string{
+		`~ formula active: true -> false`,
+		`R step-renamed send -> create contact`,
+		`+ step-added log type: "script"`,
+		`~ step-changed create contact properties.method: "POST" -> "PUT"`,
+		`+ edge-added notify onFailure: "check"`,
+		`~ trigger-changed trigger 0 async: false -> true`,
+		`~ configuration-changed source required: true -> false`,
+		`+ configuration-added target type: "elementInstance"`,
+	}
+	out := d.String()
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+	if len(d.Changes) != len(expected) {
+		t.Errorf("expected %v changes, got\n%s", len(expected), out)
+	}
+
+	if !DiffFormulas(a, a).Empty() {
+		t.Errorf("expected no changes comparing a formula with itself")
+	}
+	if _, err := json.Marshal(d); err != nil {
+		t.Errorf("unable to marshal diff: %s", err)
+	}
+}
+
+func copyJSON(t *testing.T, from, to interface{}) {
+	b, err := json.Marshal(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(b, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
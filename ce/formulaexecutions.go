@@ -0,0 +1,449 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	// FormulaExecutionStepsURIFormat is the URI of the step executions of a Formula Instance Execution
+	FormulaExecutionStepsURIFormat = "/formulas/instances/executions/%s/steps"
+	// FormulaStepExecutionValuesURIFormat is the URI of the values of a step execution
+	FormulaStepExecutionValuesURIFormat = "/formulas/instances/executions/steps/%s/values"
+)
+
+// Formula Instance Execution statuses
+const (
+	ExecutionPending   = "pending"
+	ExecutionSuccess   = "success"
+	ExecutionFailed    = "failed"
+	ExecutionCancelled = "cancelled"
+)
+
+// WaitOptions controls polling of Formula Instance Executions
+type WaitOptions struct {
+	// Interval is the time between polls; if not positive the
+	// DefaultWaitOptions interval is used
+	Interval time.Duration
+	// Timeout is how long to wait before giving up; zero waits forever
+	Timeout time.Duration
+}
+
+// DefaultWaitOptions polls every 2 seconds for up to 5 minutes
+var DefaultWaitOptions = WaitOptions{
+	Interval: 2 * time.Second,
+	Timeout:  5 * time.Minute,
+}
+
+// ExecutionEvent is a change in status of a Formula Instance Execution
+type ExecutionEvent struct {
+	Execution      FormulaInstanceExecution `json:"execution"`
+	PreviousStatus string                   `json:"previousStatus,omitempty"`
+}
+
+// ExecutionTimeoutError is returned when an execution does not finish in time
+type ExecutionTimeoutError struct {
+	FormulaInstanceID string
+	ExecutionID       int
+	Status            string
+	Timeout           time.Duration
+}
+
+func (e ExecutionTimeoutError) Error() string {
+	if e.ExecutionID == 0 {
+		return fmt.Sprintf("no execution of formula instance %s started within %s", e.FormulaInstanceID, e.Timeout)
+	}
+	return fmt.Sprintf("execution %v of formula instance %s still %s after %s", e.ExecutionID, e.FormulaInstanceID, e.Status, e.Timeout)
+}
+
+// ExecutionFinished returns true if the status is success, failed or cancelled
+func ExecutionFinished(status string) bool {
+	return status == ExecutionSuccess || status == ExecutionFailed || status == ExecutionCancelled
+}
+
+// GetFormulaInstanceExecution returns a single Execution, given its ID
+func GetFormulaInstanceExecution(base, auth string, executionID string) (FormulaInstanceExecution, error) {
+	var execution FormulaInstanceExecution
+	bodybytes, status, _, err := GetFormulaInstanceExecutionID(executionID, base, auth)
+	if err != nil {
+		return execution, err
+	}
+	if status != 200 {
+		return execution, fmt.Errorf("Status code %v", status)
+	}
+	err = json.Unmarshal(bodybytes, &execution)
+	return execution, err
+}
+
+// TriggerFormulaInstanceAndWait triggers a Formula Instance and waits for the
+// execution it started to finish; status changes are passed to onEvent, which
+// may be nil
+func TriggerFormulaInstanceAndWait(base, auth string, formulaInstanceID, triggerBody string, opts WaitOptions, onEvent func(ExecutionEvent)) (FormulaInstanceExecution, error) {
+	var execution FormulaInstanceExecution
+	opts = opts.withDefaults()
+	deadline := waitDeadline(opts)
+
+	newest, err := newestExecutionID(base, auth, formulaInstanceID)
+	if err != nil {
+		return execution, err
+	}
+
+	bodybytes, status, _, err := TriggerFormulaInstance(base, auth, formulaInstanceID, triggerBody)
+	if err != nil {
+		return execution, err
+	}
+	if status != 200 {
+		return execution, fmt.Errorf("Unable to trigger formula instance %s, status code %v: %s", formulaInstanceID, status, bodybytes)
+	}
+
+	// the trigger response does not identify the execution, so wait for the
+	// first one started after it
+	for {
+		executions, err := executionsAfter(base, auth, formulaInstanceID, newest)
+		if err != nil {
+			return execution, err
+		}
+		if len(executions) > 0 {
+			execution = executions[0]
+			break
+		}
+		if pastDeadline(deadline) {
+			return execution, ExecutionTimeoutError{FormulaInstanceID: formulaInstanceID, Timeout: opts.Timeout}
+		}
+		time.Sleep(opts.Interval)
+	}
+	if onEvent != nil {
+		onEvent(ExecutionEvent{Execution: execution})
+	}
+	if ExecutionFinished(execution.Status) {
+		return execution, nil
+	}
+	return waitForExecution(base, auth, formulaInstanceID, execution, deadline, opts, onEvent)
+}
+
+// WaitForFormulaExecution polls an Execution until it finishes
+func WaitForFormulaExecution(base, auth string, formulaInstanceID string, executionID int, opts WaitOptions, onEvent func(ExecutionEvent)) (FormulaInstanceExecution, error) {
+	execution := FormulaInstanceExecution{ID: executionID}
+	opts = opts.withDefaults()
+	return waitForExecution(base, auth, formulaInstanceID, execution, waitDeadline(opts), opts, onEvent)
+}
+
+func waitForExecution(base, auth string, formulaInstanceID string, execution FormulaInstanceExecution, deadline time.Time, opts WaitOptions, onEvent func(ExecutionEvent)) (FormulaInstanceExecution, error) {
+	for {
+		current, err := GetFormulaInstanceExecution(base, auth, strconv.Itoa(execution.ID))
+		if err != nil {
+			return execution, err
+		}
+		if current.Status != execution.Status && onEvent != nil {
+			onEvent(ExecutionEvent{Execution: current, PreviousStatus: execution.Status})
+		}
+		execution = current
+		if ExecutionFinished(execution.Status) {
+			return execution, nil
+		}
+		if pastDeadline(deadline) {
+			return execution, ExecutionTimeoutError{
+				FormulaInstanceID: formulaInstanceID,
+				ExecutionID:       execution.ID,
+				Status:            execution.Status,
+				Timeout:           opts.Timeout,
+			}
+		}
+		time.Sleep(opts.Interval)
+	}
+}
+
+// TailFormulaInstanceExecutions polls a Formula Instance's Executions and
+// passes every new execution and status change to onEvent, which may be nil,
+// until stop is closed or the timeout passes; existing executions are not
+// reported unless their status changes
+func TailFormulaInstanceExecutions(base, auth string, formulaInstanceID string, opts WaitOptions, stop <-chan struct{}, onEvent func(ExecutionEvent)) error {
+	opts = opts.withDefaults()
+	deadline := waitDeadline(opts)
+	known := make(map[int]string)
+	// executions up to started existed before tailing and are only
+	// reported when a status seen on the first page changes
+	started, newest := 0, 0
+	first := true
+	for {
+		var executions []FormulaInstanceExecution
+		var err error
+		if first {
+			executions, err = firstExecutionsPage(base, auth, formulaInstanceID)
+		} else {
+			// read back to the oldest execution that may still change
+			floor := newest
+			for id, status := range known {
+				if !ExecutionFinished(status) && id <= floor {
+					floor = id - 1
+				}
+			}
+			executions, err = executionsAfter(base, auth, formulaInstanceID, floor)
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range executions {
+			previous, ok := known[e.ID]
+			known[e.ID] = e.Status
+			if e.ID > newest {
+				newest = e.ID
+			}
+			if first {
+				started = newest
+			}
+			if first || (ok && previous == e.Status) || (!ok && e.ID <= started) || onEvent == nil {
+				continue
+			}
+			onEvent(ExecutionEvent{Execution: e, PreviousStatus: previous})
+		}
+		first = false
+		if pastDeadline(deadline) {
+			return nil
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// firstExecutionsPage returns the newest page of a Formula Instance's Executions
+func firstExecutionsPage(base, auth string, formulaInstanceID string) ([]FormulaInstanceExecution, error) {
+	var executions []FormulaInstanceExecution
+	err := EachFormulaInstanceExecutionsPage(base, auth, formulaInstanceID, 0, func(page []FormulaInstanceExecution) bool {
+		executions = page
+		return false
+	})
+	return executions, err
+}
+
+// newestExecutionID returns the ID of the newest Execution of a Formula
+// Instance, or 0 if it has none
+func newestExecutionID(base, auth string, formulaInstanceID string) (int, error) {
+	executions, err := firstExecutionsPage(base, auth, formulaInstanceID)
+	newest := 0
+	for _, e := range executions {
+		if e.ID > newest {
+			newest = e.ID
+		}
+	}
+	return newest, err
+}
+
+// executionsAfter returns, oldest first, the Executions of a Formula Instance
+// with an ID above floor, paging until it reaches older executions
+func executionsAfter(base, auth string, formulaInstanceID string, floor int) ([]FormulaInstanceExecution, error) {
+	var executions []FormulaInstanceExecution
+	err := EachFormulaInstanceExecutionsPage(base, auth, formulaInstanceID, 0, func(page []FormulaInstanceExecution) bool {
+		older := false
+		for _, e := range page {
+			if e.ID > floor {
+				executions = append(executions, e)
+			} else {
+				older = true
+			}
+		}
+		return !older
+	})
+	sort.Slice(executions, func(i, j int) bool { return executions[i].ID < executions[j].ID })
+	return executions, err
+}
+
+// withDefaults replaces a non-positive Interval with the default, so polling
+// never spins
+func (o WaitOptions) withDefaults() WaitOptions {
+	if o.Interval <= 0 {
+		o.Interval = DefaultWaitOptions.Interval
+	}
+	return o
+}
+
+func waitDeadline(opts WaitOptions) time.Time {
+	if opts.Timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(opts.Timeout)
+}
+
+func pastDeadline(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// FormulaExecutionDetails is a Formula Instance Execution with the steps it ran
+type FormulaExecutionDetails struct {
+	FormulaInstanceExecution
+	StepExecutions []StepExecution `json:"stepExecutions"`
+}
+
+// StepExecution is the execution of a single Formula step
+type StepExecution struct {
+	ID                  int                  `json:"id"`
+	StepName            string               `json:"stepName"`
+	StepType            string               `json:"stepType,omitempty"`
+	Status              string               `json:"status"`
+	CreatedDate         time.Time            `json:"createdDate"`
+	UpdatedDate         time.Time            `json:"updatedDate"`
+	StepExecutionValues []StepExecutionValue `json:"stepExecutionValues,omitempty"`
+}
+
+// StepExecutionValue is an input or output of a step execution, keyed by
+// step name and value, such as "getContact.request" or "getContact.response"
+type StepExecutionValue struct {
+	ID    int    `json:"id,omitempty"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Duration is the time the step took to run
+func (s StepExecution) Duration() time.Duration {
+	if s.CreatedDate.IsZero() || s.UpdatedDate.Before(s.CreatedDate) {
+		return 0
+	}
+	return s.UpdatedDate.Sub(s.CreatedDate)
+}
+
+// Value returns the value with the given suffix, such as "request", "response" or "error"
+func (s StepExecution) Value(suffix string) (string, bool) {
+	for _, v := range s.StepExecutionValues {
+		if v.Key == suffix || strings.HasSuffix(v.Key, "."+suffix) {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+// ErrorMessage returns the error recorded for the step, if any
+func (s StepExecution) ErrorMessage() string {
+	v, _ := s.Value("error")
+	return v
+}
+
+// GetFormulaExecutionSteps returns the step executions of a Formula Instance Execution
+func GetFormulaExecutionSteps(base, auth string, executionID string) ([]StepExecution, error) {
+	var steps []StepExecution
+	url := fmt.Sprintf("%s%s", base, fmt.Sprintf(FormulaExecutionStepsURIFormat, executionID))
+	bodybytes, status, _, err := Execute("GET", url, auth)
+	if err != nil {
+		return steps, err
+	}
+	if status != 200 {
+		return steps, fmt.Errorf("Status code %v", status)
+	}
+	err = json.Unmarshal(bodybytes, &steps)
+	return steps, err
+}
+
+// GetStepExecutionValues returns the inputs and outputs of a step execution
+func GetStepExecutionValues(base, auth string, stepExecutionID string) ([]StepExecutionValue, error) {
+	var values []StepExecutionValue
+	url := fmt.Sprintf("%s%s", base, fmt.Sprintf(FormulaStepExecutionValuesURIFormat, stepExecutionID))
+	bodybytes, status, _, err := Execute("GET", url, auth)
+	if err != nil {
+		return values, err
+	}
+	if status != 200 {
+		return values, fmt.Errorf("Status code %v", status)
+	}
+	err = json.Unmarshal(bodybytes, &values)
+	return values, err
+}
+
+// GetFormulaExecutionDetails returns an Execution with its step executions and
+// their values, retrieving whatever the execution response does not include
+func GetFormulaExecutionDetails(base, auth string, executionID string) (FormulaExecutionDetails, error) {
+	var details FormulaExecutionDetails
+	bodybytes, status, _, err := GetFormulaInstanceExecutionID(executionID, base, auth)
+	if err != nil {
+		return details, err
+	}
+	if status != 200 {
+		return details, fmt.Errorf("Status code %v", status)
+	}
+	err = json.Unmarshal(bodybytes, &details)
+	if err != nil {
+		return details, err
+	}
+	if len(details.StepExecutions) == 0 {
+		details.StepExecutions, err = GetFormulaExecutionSteps(base, auth, executionID)
+		if err != nil {
+			return details, err
+		}
+	}
+	for i, s := range details.StepExecutions {
+		if len(s.StepExecutionValues) > 0 {
+			continue
+		}
+		values, err := GetStepExecutionValues(base, auth, strconv.Itoa(s.ID))
+		if err != nil {
+			return details, err
+		}
+		details.StepExecutions[i].StepExecutionValues = values
+	}
+	sortStepExecutions(details.StepExecutions)
+	return details, nil
+}
+
+// sortStepExecutions orders steps in the order they ran
+func sortStepExecutions(steps []StepExecution) {
+	sort.SliceStable(steps, func(i, j int) bool {
+		if !steps[i].CreatedDate.Equal(steps[j].CreatedDate) {
+			return steps[i].CreatedDate.Before(steps[j].CreatedDate)
+		}
+		return steps[i].ID < steps[j].ID
+	})
+}
+
+// WriteFormulaExecutionTimeline writes an ASCII table of an Execution's steps
+// in the order they ran, with their status, start offset and duration
+func WriteFormulaExecutionTimeline(w io.Writer, details FormulaExecutionDetails) error {
+	steps := make([]StepExecution, len(details.StepExecutions))
+	copy(steps, details.StepExecutions)
+	sortStepExecutions(steps)
+
+	fmt.Fprintf(w, "Execution %v of formula instance %v: %s\n\n", details.ID, details.FormulaInstanceID, details.Status)
+
+	start := details.CreateDate
+	if start.IsZero() && len(steps) > 0 {
+		start = steps[0].CreatedDate
+	}
+	data := [][]string{}
+	for i, s := range steps {
+		errmsg := []rune(s.ErrorMessage())
+		if len(errmsg) > 60 {
+			errmsg = append(errmsg[:57], []rune("...")...)
+		}
+		data = append(data, []string{
+			strconv.Itoa(i + 1),
+			s.StepName,
+			s.Status,
+			"+" + s.CreatedDate.Sub(start).String(),
+			s.Duration().String(),
+			string(errmsg),
+		})
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"#", "Step", "Status", "Started", "Duration", "Error"})
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+
+	if !details.UpdatedDate.IsZero() && !details.CreateDate.IsZero() {
+		fmt.Fprintf(w, "\nTotal duration %s\n", details.UpdatedDate.Sub(details.CreateDate))
+	}
+	return nil
+}
+
+// FormulaExecutionTimelineOutput prints the timeline of an Execution to stdout
+func FormulaExecutionTimelineOutput(details FormulaExecutionDetails) error {
+	return WriteFormulaExecutionTimeline(os.Stdout, details)
+}
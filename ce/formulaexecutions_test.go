@@ -0,0 +1,288 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// executionServer simulates a Formula Instance whose trigger starts an
+// execution that advances one status per poll, followed by busy other
+// executions; executions are listed newest first, pageSize at a time
+type executionServer struct {
+	mu         sync.Mutex
+	executions []FormulaInstanceExecution
+	statuses   []string
+	busy       int
+	pageSize   int
+	triggered  int
+}
+
+func (s *executionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/executions"):
+		s.executions = append(s.executions, FormulaInstanceExecution{ID: 100 + len(s.executions), FormulaInstanceID: 5, Status: s.statuses[0]})
+		s.triggered = len(s.executions) - 1
+		for i := 0; i < s.busy; i++ {
+			s.executions = append(s.executions, FormulaInstanceExecution{ID: 100 + len(s.executions), FormulaInstanceID: 5, Status: ExecutionSuccess})
+		}
+		w.Write([]byte(`{"requestId":"abc"}`))
+	case strings.HasSuffix(r.URL.Path, "/executions"):
+		var newest []FormulaInstanceExecution
+		for i := len(s.executions) - 1; i >= 0; i-- {
+			newest = append(newest, s.executions[i])
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("nextPage"))
+		end := len(newest)
+		if s.pageSize > 0 && start+s.pageSize < end {
+			end = start + s.pageSize
+			w.Header().Set(NextPageTokenHeader, strconv.Itoa(end))
+		}
+		json.NewEncoder(w).Encode(newest[start:end])
+	default:
+		// GET /formulas/instances/executions/{id} advances the triggered execution
+		e := &s.executions[s.triggered]
+		for i, status := range s.statuses {
+			if status == e.Status && i+1 < len(s.statuses) {
+				e.Status = s.statuses[i+1]
+				break
+			}
+		}
+		json.NewEncoder(w).Encode(e)
+	}
+}
+
+func TestTriggerFormulaInstanceAndWait(t *testing.T) {
+	server := &executionServer{
+		executions: []FormulaInstanceExecution{{ID: 99, FormulaInstanceID: 5, Status: ExecutionSuccess}},
+		statuses:   []string{ExecutionPending, "started", ExecutionFailed},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	var events []string
+	opts := WaitOptions{Interval: time.Millisecond, Timeout: time.Second}
+	execution, err := TriggerFormulaInstanceAndWait(ts.URL, auth, "5", "{}", opts, func(e ExecutionEvent) {
+		events = append(events, e.PreviousStatus+">"+e.Execution.Status)
+	})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if execution.ID != 101 || execution.Status != ExecutionFailed {
+		t.Errorf("unexpected execution %+v", execution)
+	}
+	if strings.Join(events, ",") != ">pending,pending>started,started>failed" {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestTriggerFormulaInstanceAndWaitBusy(t *testing.T) {
+	server := &executionServer{
+		executions: []FormulaInstanceExecution{{ID: 99, FormulaInstanceID: 5, Status: ExecutionSuccess}},
+		statuses:   []string{ExecutionPending, ExecutionSuccess},
+		busy:       3,
+		pageSize:   2,
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// executions started after the trigger push it off the first page
+	opts := WaitOptions{Interval: time.Millisecond, Timeout: time.Second}
+	execution, err := TriggerFormulaInstanceAndWait(ts.URL, auth, "5", "{}", opts, nil)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if execution.ID != 101 || execution.Status != ExecutionSuccess {
+		t.Errorf("unexpected execution %+v", execution)
+	}
+}
+
+func TestWaitForFormulaExecutionTimeout(t *testing.T) {
+	server := &executionServer{
+		executions: []FormulaInstanceExecution{{ID: 100, FormulaInstanceID: 5, Status: ExecutionPending}},
+		statuses:   []string{ExecutionPending},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	_, err := WaitForFormulaExecution(ts.URL, auth, "5", 100, WaitOptions{Interval: time.Millisecond, Timeout: 10 * time.Millisecond}, nil)
+	if _, ok := err.(ExecutionTimeoutError); !ok {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestTailFormulaInstanceExecutions(t *testing.T) {
+	server := &executionServer{
+		executions: []FormulaInstanceExecution{{ID: 99, FormulaInstanceID: 5, Status: ExecutionSuccess}},
+		statuses:   []string{ExecutionPending},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	stop := make(chan struct{})
+	var events []ExecutionEvent
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.mu.Lock()
+		server.executions = append(server.executions, FormulaInstanceExecution{ID: 100, Status: ExecutionPending})
+		server.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		server.mu.Lock()
+		server.executions[1].Status = ExecutionSuccess
+		server.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		close(stop)
+	}()
+	err := TailFormulaInstanceExecutions(ts.URL, auth, "5", WaitOptions{Interval: time.Millisecond}, stop, func(e ExecutionEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(events) != 2 || events[0].Execution.ID != 100 || events[1].PreviousStatus != ExecutionPending || events[1].Execution.Status != ExecutionSuccess {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestTailFormulaInstanceExecutionsBusy(t *testing.T) {
+	server := &executionServer{
+		executions: []FormulaInstanceExecution{{ID: 99, FormulaInstanceID: 5, Status: ExecutionSuccess}},
+		statuses:   []string{ExecutionPending},
+		pageSize:   2,
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	stop := make(chan struct{})
+	var mu sync.Mutex
+	var ids []int
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.mu.Lock()
+		for id := 100; id < 105; id++ {
+			server.executions = append(server.executions, FormulaInstanceExecution{ID: id, Status: ExecutionSuccess})
+		}
+		server.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		close(stop)
+	}()
+	err := TailFormulaInstanceExecutions(ts.URL, auth, "5", WaitOptions{Interval: time.Millisecond}, stop, func(e ExecutionEvent) {
+		mu.Lock()
+		ids = append(ids, e.Execution.ID)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 5 || ids[0] != 100 || ids[4] != 104 {
+		t.Errorf("expected every new execution across pages, got %v", ids)
+	}
+}
+
+func TestTailFormulaInstanceExecutionsDefaults(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls++
+		mu.Unlock()
+		w.Write([]byte(`[{"id":100,"status":"pending"}]`))
+	}))
+	defer ts.Close()
+
+	// a zero interval falls back to the default rather than busy-polling,
+	// and a nil onEvent is allowed
+	stop := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stop)
+	}()
+	err := TailFormulaInstanceExecutions(ts.URL, auth, "5", WaitOptions{}, stop, nil)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if polls != 1 {
+		t.Errorf("expected 1 poll, got %v", polls)
+	}
+}
+
+func TestGetFormulaExecutionDetails(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/formulas/instances/executions/100":
+			w.Write([]byte(`{"id":100,"formulaInstanceId":5,"status":"failed",
+				"createdDate":"2018-01-01T00:00:00Z","updatedDate":"2018-01-01T00:00:03Z"}`))
+		case "/formulas/instances/executions/100/steps":
+			w.Write([]byte(`[
+				{"id":2,"stepName":"send","status":"failed","createdDate":"2018-01-01T00:00:01Z","updatedDate":"2018-01-01T00:00:03Z"},
+				{"id":1,"stepName":"trigger","status":"success","createdDate":"2018-01-01T00:00:00Z","updatedDate":"2018-01-01T00:00:00.5Z"}
+			]`))
+		case "/formulas/instances/executions/steps/1/values":
+			w.Write([]byte(`[{"key":"trigger.event","value":"{}"}]`))
+		case "/formulas/instances/executions/steps/2/values":
+			w.Write([]byte(`[{"key":"send.request","value":"{}"},{"key":"send.error","value":"400 Bad Request"}]`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	details, err := GetFormulaExecutionDetails(ts.URL, auth, "100")
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(details.StepExecutions) != 2 || details.StepExecutions[0].StepName != "trigger" {
+		t.Fatalf("unexpected steps %+v", details.StepExecutions)
+	}
+	send := details.StepExecutions[1]
+	if send.ErrorMessage() != "400 Bad Request" || send.Duration() != 2*time.Second {
+		t.Errorf("unexpected step %+v", send)
+	}
+
+	var buf bytes.Buffer
+	err = WriteFormulaExecutionTimeline(&buf, details)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	out := buf.String()
+	for _, s := range []string{"Execution 100 of formula instance 5: failed", "trigger", "+1s", "2s", "400 Bad Request", "Total duration 3s"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("missing %q in\n%s", s, out)
+		}
+	}
+	if strings.Index(out, "trigger") > strings.Index(out, "send") {
+		t.Errorf("steps out of order\n%s", out)
+	}
+}
+
+func TestFormulaExecutionTimelineTruncatesRunes(t *testing.T) {
+	details := FormulaExecutionDetails{
+		StepExecutions: []StepExecution{{
+			StepName:            "send",
+			Status:              ExecutionFailed,
+			StepExecutionValues: []StepExecutionValue{{Key: "send.error", Value: strings.Repeat("é", 70)}},
+		}},
+	}
+	var buf bytes.Buffer
+	err := WriteFormulaExecutionTimeline(&buf, details)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	out := buf.String()
+	if !utf8.ValidString(out) || !strings.Contains(out, strings.Repeat("é", 57)+"...") || strings.Contains(out, strings.Repeat("é", 58)) {
+		t.Errorf("unexpected truncation\n%s", out)
+	}
+}
@@ -0,0 +1,131 @@
+package ce
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// formulaGraph is a Formula flattened to nodes and edges for rendering
+type formulaGraph struct {
+	nodes []graphNode
+	edges []graphEdge
+}
+
+type graphNode struct {
+	id      string
+	label   string
+	kind    string
+	trigger bool
+	missing bool
+}
+
+type graphEdge struct {
+	from, to string
+	failure  bool
+}
+
+// newFormulaGraph walks Triggers and Steps along their OnSuccess/OnFailure edges;
+// references to nonexistent steps become missing nodes
+func newFormulaGraph(f Formula) formulaGraph {
+	var g formulaGraph
+	ids := make(map[string]string)
+	for i, s := range f.Steps {
+		if _, ok := ids[s.Name]; ok {
+			continue
+		}
+		id := "s" + strconv.Itoa(i)
+		ids[s.Name] = id
+		g.nodes = append(g.nodes, graphNode{id: id, label: s.Name, kind: s.Type})
+	}
+	target := func(name string) string {
+		id, ok := ids[name]
+		if !ok {
+			id = "m" + strconv.Itoa(len(ids))
+			ids[name] = id
+			g.nodes = append(g.nodes, graphNode{id: id, label: name, kind: "missing", missing: true})
+		}
+		return id
+	}
+	link := func(from string, onSuccess, onFailure []string) {
+		for _, n := range onSuccess {
+			g.edges = append(g.edges, graphEdge{from: from, to: target(n)})
+		}
+		for _, n := range onFailure {
+			g.edges = append(g.edges, graphEdge{from: from, to: target(n), failure: true})
+		}
+	}
+	for i, t := range f.Triggers {
+		id := "t" + strconv.Itoa(i)
+		g.nodes = append(g.nodes, graphNode{id: id, label: "trigger", kind: t.Type, trigger: true})
+		link(id, t.OnSuccess, t.OnFailure)
+	}
+	for _, s := range f.Steps {
+		link(ids[s.Name], s.OnSuccess, s.OnFailure)
+	}
+	return g
+}
+
+// FormulaDOT renders a Formula's triggers and steps as a Graphviz DOT digraph;
+// success edges are solid green and failure edges dashed red
+func FormulaDOT(f Formula) string {
+	g := newFormulaGraph(f)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(f.Name))
+	fmt.Fprintf(&b, "  rankdir=TB;\n")
+	fmt.Fprintf(&b, "  node [shape=box, style=rounded, fontname=\"Helvetica\"];\n")
+	for _, n := range g.nodes {
+		attrs := fmt.Sprintf("label=%s", strconv.Quote(fmt.Sprintf("%s\n(%s)", n.label, n.kind)))
+		switch {
+		case n.trigger:
+			attrs += ", shape=oval, style=filled, fillcolor=lightblue"
+		case n.missing:
+			attrs += ", style=\"rounded,dashed\", color=gray"
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", n.id, attrs)
+	}
+	for _, e := range g.edges {
+		if e.failure {
+			fmt.Fprintf(&b, "  %s -> %s [label=\"failure\", color=red, style=dashed];\n", e.from, e.to)
+		} else {
+			fmt.Fprintf(&b, "  %s -> %s [label=\"success\", color=green];\n", e.from, e.to)
+		}
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+// FormulaMermaid renders a Formula's triggers and steps as a Mermaid flowchart;
+// success edges are solid and failure edges dotted red
+func FormulaMermaid(f Formula) string {
+	g := newFormulaGraph(f)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "flowchart TD\n")
+	for _, n := range g.nodes {
+		label := fmt.Sprintf("%s<br/><i>%s</i>", mermaidEscaper.Replace(n.label), mermaidEscaper.Replace(n.kind))
+		switch {
+		case n.trigger:
+			fmt.Fprintf(&b, "  %s([\"%s\"])\n", n.id, label)
+		case n.missing:
+			fmt.Fprintf(&b, "  %s{{\"%s\"}}\n", n.id, label)
+		default:
+			fmt.Fprintf(&b, "  %s[\"%s\"]\n", n.id, label)
+		}
+	}
+	var failures []string
+	for i, e := range g.edges {
+		if e.failure {
+			fmt.Fprintf(&b, "  %s -.->|failure| %s\n", e.from, e.to)
+			failures = append(failures, strconv.Itoa(i))
+		} else {
+			fmt.Fprintf(&b, "  %s -->|success| %s\n", e.from, e.to)
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Fprintf(&b, "  linkStyle %s stroke:red\n", strings.Join(failures, ","))
+	}
+	return b.String()
+}
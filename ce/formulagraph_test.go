@@ -0,0 +1,51 @@
+package ce
+
+import (
+	"strings"
+	"testing"
+)
+
+var graphFormula = Formula{
+	Name: "graph",
+	Triggers: []Trigger{
+		{Type: "event", OnSuccess: []string{"check"}},
+	},
+	Steps: []Step{
+		{Name: "check", Type: "filter", OnSuccess: []string{"send"}, OnFailure: []string{"log \"it\""}},
+		{Name: "send", Type: "elementRequest"},
+		{Name: "log \"it\"", Type: "script", OnSuccess: []string{"gone"}},
+	},
+}
+
+func TestFormulaDOT(t *testing.T) {
+	dot := FormulaDOT(graphFormula)
+	for _, line := range []string{
+		`digraph "graph" {`,
+		`t0 [label="trigger\n(event)", shape=oval`,
+		`s0 [label="check\n(filter)"];`,
+		`s2 [label="log \"it\"\n(script)"];`,
+		`s0 -> s1 [label="success", color=green];`,
+		`s0 -> s2 [label="failure", color=red, style=dashed];`,
+		`m3 [label="gone\n(missing)", style="rounded,dashed"`,
+	} {
+		if !strings.Contains(dot, line) {
+			t.Errorf("missing %q in\n%s", line, dot)
+		}
+	}
+}
+
+func TestFormulaMermaid(t *testing.T) {
+	m := FormulaMermaid(graphFormula)
+	for _, line := range []string{
+		"flowchart TD",
+		`t0(["trigger<br/><i>event</i>"])`,
+		`s2["log #quot;it#quot;<br/><i>script</i>"]`,
+		"t0 -->|success| s0",
+		"s0 -.->|failure| s2",
+		"linkStyle 2 stroke:red",
+	} {
+		if !strings.Contains(m, line) {
+			t.Errorf("missing %q in\n%s", line, m)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Formula Instance health issues
+const (
+	HealthElementInstanceMissing  = "element-instance-missing"
+	HealthElementInstanceDisabled = "element-instance-disabled"
+	HealthElementInstanceInvalid  = "element-instance-invalid"
+	HealthFormulaInactive         = "formula-inactive"
+)
+
+// Cleanup actions for unhealthy Formula Instances
+const (
+	CleanupDeactivate = "deactivate"
+	CleanupDelete     = "delete"
+)
+
+// FormulaInstanceIssue is a problem with a Formula Instance; Key and
+// ElementInstanceID are set for issues with a configured Element Instance
+type FormulaInstanceIssue struct {
+	Kind              string `json:"kind"`
+	Key               string `json:"key,omitempty"`
+	ElementInstanceID string `json:"elementInstanceId,omitempty"`
+	Message           string `json:"message"`
+}
+
+// FormulaInstanceHealth lists the issues of a Formula Instance
+type FormulaInstanceHealth struct {
+	FormulaID           int                    `json:"formulaId"`
+	FormulaName         string                 `json:"formulaName"`
+	FormulaInstanceID   int                    `json:"formulaInstanceId"`
+	FormulaInstanceName string                 `json:"formulaInstanceName"`
+	Active              bool                   `json:"active"`
+	Issues              []FormulaInstanceIssue `json:"issues"`
+}
+
+// Orphaned reports whether a configured Element Instance no longer exists
+func (h FormulaInstanceHealth) Orphaned() bool {
+	return h.HasIssue(HealthElementInstanceMissing)
+}
+
+// HasIssue reports whether the Formula Instance has an issue of one of kinds
+func (h FormulaInstanceHealth) HasIssue(kinds ...string) bool {
+	for _, i := range h.Issues {
+		for _, k := range kinds {
+			if i.Kind == k {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CleanupResult is the outcome of cleaning up an unhealthy Formula Instance
+type CleanupResult struct {
+	FormulaInstanceID int    `json:"formulaInstanceId"`
+	Action            string `json:"action"`
+	Success           bool   `json:"success"`
+	Error             string `json:"error,omitempty"`
+}
+
+// CheckFormulaInstanceHealth returns the Formula Instances, of Formulas with
+// their Instances, whose Formula is inactive or whose configured Element
+// Instances are missing from elementInstances, disabled or invalid
+func CheckFormulaInstanceHealth(formulas []Formula, elementInstances []ElementInstance) []FormulaInstanceHealth {
+	existing := make(map[string]ElementInstance)
+	for _, e := range elementInstances {
+		existing[strconv.Itoa(e.ID)] = e
+	}
+
+	var unhealthy []FormulaInstanceHealth
+	for _, f := range formulas {
+		for _, fi := range f.Instances {
+			health := FormulaInstanceHealth{
+				FormulaID:           f.ID,
+				FormulaName:         f.Name,
+				FormulaInstanceID:   fi.ID,
+				FormulaInstanceName: fi.Name,
+				Active:              fi.Active,
+			}
+			if !f.Active {
+				health.Issues = append(health.Issues, FormulaInstanceIssue{
+					Kind:    HealthFormulaInactive,
+					Message: fmt.Sprintf("formula %v %s is inactive", f.ID, f.Name),
+				})
+			}
+			values := fi.ConfigurationValues()
+			for _, c := range f.Configuration {
+				if c.Type != "elementInstance" {
+					continue
+				}
+				id, ok := values[c.Key]
+				if !ok || id == "" {
+					continue
+				}
+				e, found := existing[id]
+				issue, ok := elementInstanceIssue(c.Key, id, e, found)
+				if !ok {
+					continue
+				}
+				health.Issues = append(health.Issues, issue)
+			}
+			if len(health.Issues) > 0 {
+				unhealthy = append(unhealthy, health)
+			}
+		}
+	}
+	return unhealthy
+}
+
+// elementInstanceIssue returns the issue, if any, with a configured Element
+// Instance
+func elementInstanceIssue(key, id string, e ElementInstance, found bool) (FormulaInstanceIssue, bool) {
+	issue := FormulaInstanceIssue{Key: key, ElementInstanceID: id}
+	switch {
+	case !found:
+		issue.Kind = HealthElementInstanceMissing
+		issue.Message = fmt.Sprintf("element instance %s does not exist", id)
+	case e.Disabled:
+		issue.Kind = HealthElementInstanceDisabled
+		issue.Message = fmt.Sprintf("element instance %s (%s) is disabled", id, e.Name)
+	case !e.Valid:
+		issue.Kind = HealthElementInstanceInvalid
+		issue.Message = fmt.Sprintf("element instance %s (%s) is not valid", id, e.Name)
+	default:
+		return issue, false
+	}
+	return issue, true
+}
+
+// GetFormulaInstanceHealth checks every Formula Instance in the account
+// against the account's Element Instances; an Element Instance absent from
+// the list is only reported missing once retrieving it returns a 404
+func GetFormulaInstanceHealth(base, auth string) ([]FormulaInstanceHealth, error) {
+	formulabytes, status, _, err := FormulasList(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("Status code %v", status)
+	}
+	formulas, err := CombinedFormulaAndInstances(formulabytes, base, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	bodybytes, status, _, err := GetAllInstances(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("Unable to retrieve element instances, status code %v", status)
+	}
+	// GetAllInstances replaces a body it can't read with null
+	if string(bodybytes) == "null" {
+		return nil, fmt.Errorf("Unable to read element instances")
+	}
+	var instances []ElementInstance
+	err = json.Unmarshal(bodybytes, &instances)
+	if err != nil {
+		return nil, err
+	}
+	return confirmMissingElementInstances(base, auth, CheckFormulaInstanceHealth(formulas, instances))
+}
+
+// confirmMissingElementInstances retrieves each Element Instance reported
+// missing, keeping the issue on a 404 and otherwise checking the instance
+func confirmMissingElementInstances(base, auth string, unhealthy []FormulaInstanceHealth) ([]FormulaInstanceHealth, error) {
+	retrieved := make(map[string]*ElementInstance)
+	var confirmed []FormulaInstanceHealth
+	for _, h := range unhealthy {
+		var issues []FormulaInstanceIssue
+		for _, i := range h.Issues {
+			if i.Kind != HealthElementInstanceMissing {
+				issues = append(issues, i)
+				continue
+			}
+			e, ok := retrieved[i.ElementInstanceID]
+			if !ok {
+				bodybytes, status, _, err := GetInstanceInfo(base, auth, i.ElementInstanceID)
+				if err != nil {
+					return nil, err
+				}
+				switch status {
+				case 404:
+				case 200:
+					e = &ElementInstance{}
+					err = json.Unmarshal(bodybytes, e)
+					if err != nil {
+						return nil, fmt.Errorf("element instance %s: %s", i.ElementInstanceID, err)
+					}
+				default:
+					return nil, fmt.Errorf("Unable to retrieve element instance %s, status code %v", i.ElementInstanceID, status)
+				}
+				retrieved[i.ElementInstanceID] = e
+			}
+			if e == nil {
+				issues = append(issues, i)
+			} else if issue, ok := elementInstanceIssue(i.Key, i.ElementInstanceID, *e, true); ok {
+				issues = append(issues, issue)
+			}
+		}
+		if len(issues) > 0 {
+			h.Issues = issues
+			confirmed = append(confirmed, h)
+		}
+	}
+	return confirmed, nil
+}
+
+// CleanupFormulaInstances deactivates or deletes the unhealthy Formula
+// Instances with an issue of one of kinds, by default only orphaned ones;
+// deactivating skips instances that are already inactive. An inactive
+// Formula is never a reason to delete its Instances.
+func CleanupFormulaInstances(base, auth string, unhealthy []FormulaInstanceHealth, action string, kinds ...string) ([]CleanupResult, error) {
+	if action != CleanupDeactivate && action != CleanupDelete {
+		return nil, fmt.Errorf("unknown cleanup action %s", action)
+	}
+	if len(kinds) == 0 {
+		kinds = []string{HealthElementInstanceMissing}
+	}
+	for _, k := range kinds {
+		switch k {
+		case HealthElementInstanceMissing, HealthElementInstanceDisabled, HealthElementInstanceInvalid:
+		case HealthFormulaInactive:
+			if action == CleanupDelete {
+				return nil, fmt.Errorf("formula instances are not deleted for %s", k)
+			}
+		default:
+			return nil, fmt.Errorf("unknown health issue %s", k)
+		}
+	}
+	var results []CleanupResult
+	for _, h := range unhealthy {
+		if !h.HasIssue(kinds...) {
+			continue
+		}
+		if action == CleanupDeactivate && !h.Active {
+			continue
+		}
+		id := strconv.Itoa(h.FormulaInstanceID)
+		result := CleanupResult{FormulaInstanceID: h.FormulaInstanceID, Action: action, Success: true}
+		switch action {
+		case CleanupDeactivate:
+			_, err := SetFormulaInstanceActive(base, auth, h.FormulaID, id, false)
+			if err != nil {
+				result.Success = false
+				result.Error = err.Error()
+			}
+		case CleanupDelete:
+			_, status, _, err := DeleteFormulaInstance(base, auth, id)
+			if err != nil {
+				result.Success = false
+				result.Error = err.Error()
+			} else if status != 200 && status != 204 {
+				result.Success = false
+				result.Error = fmt.Sprintf("Status code %v", status)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
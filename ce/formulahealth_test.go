@@ -0,0 +1,125 @@
+package ce
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGetFormulaInstanceHealth(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.Method != "GET" {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+		}
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/formulas":
+			w.Write([]byte(`[
+				{"id": 1, "name": "sync", "active": true, "triggers": [{"type": "event"}],
+					"configuration": [{"key": "crm", "type": "elementInstance"}, {"key": "target", "type": "elementInstance"}, {"key": "label", "type": "value"}]},
+				{"id": 2, "name": "old", "active": false, "triggers": [{"type": "manual"}], "configuration": []}
+			]`))
+		case r.URL.Path == "/formulas/1/instances":
+			w.Write([]byte(`[
+				{"id": 10, "name": "healthy", "active": true, "configuration": {"crm": "100", "target": "101", "label": "999"}},
+				{"id": 11, "name": "orphan", "active": true, "configuration": {"crm": "404", "target": 101}},
+				{"id": 12, "name": "broken", "active": false, "configuration": {"crm": "102", "target": "103"}},
+				{"id": 13, "name": "unlisted", "active": true, "configuration": {"crm": "104", "target": "105"}}
+			]`))
+		case r.URL.Path == "/formulas/2/instances":
+			w.Write([]byte(`[{"id": 20, "name": "stale", "active": true, "configuration": {}}]`))
+		case r.URL.Path == "/instances":
+			w.Write([]byte(`[
+				{"id": 100, "name": "sfdc", "valid": true},
+				{"id": 101, "name": "hubspot", "valid": true},
+				{"id": 102, "name": "disabled", "valid": true, "disabled": true},
+				{"id": 103, "name": "expired", "valid": false}
+			]`))
+		case r.URL.Path == "/instances/104":
+			w.Write([]byte(`{"id": 104, "name": "paged", "valid": true}`))
+		case r.URL.Path == "/instances/105":
+			w.Write([]byte(`{"id": 105, "name": "paged", "valid": false}`))
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/formulas/instances/"):
+			id := strings.TrimPrefix(r.URL.Path, "/formulas/instances/")
+			w.Write([]byte(`{"id": ` + id + `, "name": "i", "active": true, "formula": {"id": 1}, "configuration": {}}`))
+		case r.Method == "PUT" || r.Method == "DELETE":
+			ioutil.ReadAll(r.Body)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	unhealthy, err := GetFormulaInstanceHealth(ts.URL, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range unhealthy {
+		for _, i := range h.Issues {
+			got = append(got, h.FormulaInstanceName+":"+i.Kind+":"+i.Key+":"+i.ElementInstanceID)
+		}
+	}
+	want := []string{
+		"orphan:element-instance-missing:crm:404",
+		"broken:element-instance-disabled:crm:102",
+		"broken:element-instance-invalid:target:103",
+		"unlisted:element-instance-invalid:target:105",
+		"stale:formula-inactive::",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected issues\nwant %v\ngot  %v", want, got)
+	}
+	if !unhealthy[0].Orphaned() || unhealthy[1].Orphaned() {
+		t.Errorf("unexpected orphaned status")
+	}
+
+	requests = nil
+	results, err := CleanupFormulaInstances(ts.URL, auth, unhealthy, CleanupDeactivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Success || results[0].FormulaInstanceID != 11 {
+		t.Errorf("unexpected deactivate results %+v", results)
+	}
+	if len(requests) != 1 || requests[0] != "PUT /formulas/1/instances/11" {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	requests = nil
+	results, err = CleanupFormulaInstances(ts.URL, auth, unhealthy, CleanupDelete, HealthElementInstanceInvalid)
+	if err != nil || len(results) != 2 || results[0].FormulaInstanceID != 12 || results[1].FormulaInstanceID != 13 {
+		t.Errorf("unexpected delete results %+v %v", results, err)
+	}
+	sort.Strings(requests)
+	if strings.Join(requests, ",") != "DELETE /formulas/1/instances/12,DELETE /formulas/1/instances/13" {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	requests = nil
+	if _, err = CleanupFormulaInstances(ts.URL, auth, unhealthy, CleanupDelete, HealthFormulaInactive); err == nil || len(requests) > 0 {
+		t.Errorf("expected no deletes for inactive formulas, got %v %v", requests, err)
+	}
+	if _, err = CleanupFormulaInstances(ts.URL, auth, unhealthy, "archive"); err == nil {
+		t.Errorf("expected error for unknown action")
+	}
+}
+
+func TestCheckFormulaInstanceHealthJSON(t *testing.T) {
+	f := Formula{ID: 3, Name: "f", Active: true, Configuration: []Configuration{{Key: "crm", Type: "elementInstance"}}}
+	f.Instances = []FormulaInstance{{ID: 30, Configuration: map[string]interface{}{"crm": 5.0}}}
+	unhealthy := CheckFormulaInstanceHealth([]Formula{f}, nil)
+	b, _ := json.Marshal(unhealthy)
+	if string(b) != `[{"formulaId":3,"formulaName":"f","formulaInstanceId":30,"formulaInstanceName":"","active":false,"issues":[{"kind":"element-instance-missing","key":"crm","elementInstanceId":"5","message":"element instance 5 does not exist"}]}]` {
+		t.Errorf("unexpected health %s", b)
+	}
+}
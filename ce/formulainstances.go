@@ -0,0 +1,293 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigurationProblem is a problem with a Formula Instance's configuration
+type ConfigurationProblem struct {
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p ConfigurationProblem) String() string {
+	if p.Key == "" {
+		return p.Message
+	}
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+// ConfigurationProblems is returned as an error when a Formula Instance
+// configuration does not satisfy its Formula
+type ConfigurationProblems []ConfigurationProblem
+
+func (ps ConfigurationProblems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("invalid formula instance configuration: %s", strings.Join(lines, "; "))
+}
+
+// ElementInstanceLookup returns an Element Instance given its ID; found is
+// false if the instance does not exist
+type ElementInstanceLookup func(instanceID string) (instance ElementInstance, found bool, err error)
+
+// PlatformElementInstanceLookup looks up Element Instances with GetInstanceInfo
+func PlatformElementInstanceLookup(base, auth string) ElementInstanceLookup {
+	return func(instanceID string) (ElementInstance, bool, error) {
+		var instance ElementInstance
+		bodybytes, status, _, err := GetInstanceInfo(base, auth, instanceID)
+		if err != nil {
+			return instance, false, err
+		}
+		if status == 404 {
+			return instance, false, nil
+		}
+		if status != 200 {
+			return instance, false, fmt.Errorf("Unable to retrieve element instance %s, status code %v", instanceID, status)
+		}
+		err = json.Unmarshal(bodybytes, &instance)
+		return instance, err == nil, err
+	}
+}
+
+// ValidateFormulaInstanceConfig checks a Formula Instance configuration against
+// the Formula's declared Configuration: every required key must be present,
+// undeclared keys are reported, and elementInstance values must refer to
+// existing, enabled Element Instances
+func ValidateFormulaInstanceConfig(f Formula, config FormulaInstanceConfig, lookup ElementInstanceLookup) (ConfigurationProblems, error) {
+	var problems ConfigurationProblems
+	if strings.TrimSpace(config.Name) == "" {
+		problems = append(problems, ConfigurationProblem{Message: "name is required"})
+	}
+
+	values := make(map[string]interface{})
+	if config.Configuration != nil {
+		m, ok := normalizeJSON(config.Configuration).(map[string]interface{})
+		if !ok {
+			problems = append(problems, ConfigurationProblem{Message: "configuration must be an object of key/value pairs"})
+			return problems, nil
+		}
+		values = m
+	}
+
+	declared := make(map[string]Configuration)
+	for _, c := range f.Configuration {
+		declared[c.Key] = c
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := declared[key]; !ok {
+			problems = append(problems, ConfigurationProblem{Key: key, Message: fmt.Sprintf("not a configuration key of formula %q", f.Name)})
+		}
+	}
+
+	for _, c := range f.Configuration {
+		v, present := values[c.Key]
+		s := configValueString(v)
+		if !present || s == "" {
+			if c.Required {
+				problems = append(problems, ConfigurationProblem{Key: c.Key, Message: fmt.Sprintf("required %s is missing", configTypeName(c))})
+			}
+			continue
+		}
+		switch c.Type {
+		case "elementInstance":
+			if _, err := strconv.Atoi(s); err != nil {
+				problems = append(problems, ConfigurationProblem{Key: c.Key, Message: fmt.Sprintf("element instance ID %q is not a number", s)})
+				continue
+			}
+			if lookup == nil {
+				continue
+			}
+			instance, found, err := lookup(s)
+			if err != nil {
+				return problems, err
+			}
+			switch {
+			case !found:
+				problems = append(problems, ConfigurationProblem{Key: c.Key, Message: fmt.Sprintf("element instance %s does not exist", s)})
+			case instance.Disabled:
+				problems = append(problems, ConfigurationProblem{Key: c.Key, Message: fmt.Sprintf("element instance %s (%s) is disabled", s, instance.Name)})
+			case !instance.Valid:
+				problems = append(problems, ConfigurationProblem{Key: c.Key, Message: fmt.Sprintf("element instance %s (%s) is not valid", s, instance.Name)})
+			}
+		default:
+			if _, ok := v.(map[string]interface{}); ok {
+				problems = append(problems, ConfigurationProblem{Key: c.Key, Message: "value must be a string, not an object"})
+			}
+		}
+	}
+	return problems, nil
+}
+
+func configTypeName(c Configuration) string {
+	if c.Type == "" {
+		return "value"
+	}
+	return c.Type
+}
+
+func configValueString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// ValidateFormulaInstanceConfigFor retrieves a Formula and validates a Formula
+// Instance configuration against it, looking up Element Instances on the Platform
+func ValidateFormulaInstanceConfigFor(base, auth string, formulaID string, config FormulaInstanceConfig) (ConfigurationProblems, error) {
+	f, err := getFormula(base, auth, formulaID)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateFormulaInstanceConfig(f, config, PlatformElementInstanceLookup(base, auth))
+}
+
+// CreateValidatedFormulaInstance validates the configuration before calling
+// CreateFormulaInstance; if there are problems no instance is created and a
+// ConfigurationProblems error is returned
+func CreateValidatedFormulaInstance(base, auth string, formulaTemplateID string, config FormulaInstanceConfig) ([]byte, int, string, error) {
+	problems, err := ValidateFormulaInstanceConfigFor(base, auth, formulaTemplateID, config)
+	if err != nil {
+		return nil, -1, "", err
+	}
+	if len(problems) > 0 {
+		return nil, -1, "", problems
+	}
+	return CreateFormulaInstance(base, auth, formulaTemplateID, config)
+}
+
+// FormulaInstanceUpdate holds changes to a Formula Instance; nil fields are left as-is
+type FormulaInstanceUpdate struct {
+	Name          *string
+	Active        *bool
+	Configuration interface{}
+	Settings      interface{}
+}
+
+// formulaInstanceRequest is the body sent to update a Formula Instance
+type formulaInstanceRequest struct {
+	Name          string      `json:"name"`
+	Active        bool        `json:"active"`
+	Configuration interface{} `json:"configuration,omitempty"`
+	Settings      interface{} `json:"settings,omitempty"`
+}
+
+// FormulaInstanceUpdateResult is the outcome of updating one Formula Instance
+type FormulaInstanceUpdateResult struct {
+	InstanceID int    `json:"instanceId"`
+	Name       string `json:"name"`
+	Active     bool   `json:"active"`
+	Changed    bool   `json:"changed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// GetFormulaInstance returns a Formula Instance given its ID
+func GetFormulaInstance(base, auth string, instanceID string) (FormulaInstance, error) {
+	var fi FormulaInstance
+	url := fmt.Sprintf("%s%s", base, fmt.Sprintf(FormulaInstanceDetailsURIFormat, instanceID))
+	bodybytes, status, _, err := Execute("GET", url, auth)
+	if err != nil {
+		return fi, err
+	}
+	if status != 200 {
+		return fi, fmt.Errorf("Unable to retrieve formula instance %s, status code %v", instanceID, status)
+	}
+	err = json.Unmarshal(bodybytes, &fi)
+	return fi, err
+}
+
+// UpdateFormulaInstance applies changes to an Instance of a Formula and
+// returns the updated instance
+func UpdateFormulaInstance(base, auth string, formulaID int, instanceID string, update FormulaInstanceUpdate) (FormulaInstance, error) {
+	fi, err := GetFormulaInstance(base, auth, instanceID)
+	if err != nil {
+		return fi, err
+	}
+	request := formulaInstanceRequest{
+		Name:          fi.Name,
+		Active:        fi.Active,
+		Configuration: fi.Configuration,
+		Settings:      fi.Settings,
+	}
+	if update.Name != nil {
+		request.Name = *update.Name
+	}
+	if update.Active != nil {
+		request.Active = *update.Active
+	}
+	if update.Configuration != nil {
+		request.Configuration = update.Configuration
+	}
+	if update.Settings != nil {
+		request.Settings = update.Settings
+	}
+	requestbytes, err := json.Marshal(request)
+	if err != nil {
+		return fi, err
+	}
+	url := fmt.Sprintf("%s%s", base, fmt.Sprintf(FormulaInstanceURIFormat, formulaID, instanceID))
+	bodybytes, status, _, err := ExecuteWithBody("PUT", url, auth, requestbytes)
+	if err != nil {
+		return fi, err
+	}
+	if status != 200 {
+		return fi, fmt.Errorf("Unable to update formula instance %s, status code %v: %s", instanceID, status, bodybytes)
+	}
+	var updated FormulaInstance
+	err = json.Unmarshal(bodybytes, &updated)
+	if err != nil {
+		return fi, err
+	}
+	return updated, nil
+}
+
+// RenameFormulaInstance changes the name of an Instance of a Formula
+func RenameFormulaInstance(base, auth string, formulaID int, instanceID, name string) (FormulaInstance, error) {
+	return UpdateFormulaInstance(base, auth, formulaID, instanceID, FormulaInstanceUpdate{Name: &name})
+}
+
+// SetFormulaInstanceActive activates or deactivates an Instance of a Formula
+func SetFormulaInstanceActive(base, auth string, formulaID int, instanceID string, active bool) (FormulaInstance, error) {
+	return UpdateFormulaInstance(base, auth, formulaID, instanceID, FormulaInstanceUpdate{Active: &active})
+}
+
+// SetFormulaInstancesActive activates or deactivates every instance of a
+// Formula; instances already in the requested state are left unchanged
+func SetFormulaInstancesActive(base, auth string, formulaID int, active bool) ([]FormulaInstanceUpdateResult, error) {
+	instances, err := GetInstancesOfFormula(formulaID, base, auth)
+	if err != nil {
+		return nil, err
+	}
+	var results []FormulaInstanceUpdateResult
+	for _, fi := range instances {
+		r := FormulaInstanceUpdateResult{InstanceID: fi.ID, Name: fi.Name, Active: fi.Active}
+		if fi.Active != active {
+			updated, err := SetFormulaInstanceActive(base, auth, formulaID, strconv.Itoa(fi.ID), active)
+			if err != nil {
+				r.Error = err.Error()
+			} else {
+				r.Active = updated.Active
+				r.Changed = true
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -0,0 +1,121 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestValidateFormulaInstanceConfig(t *testing.T) {
+	f := Formula{
+		Name: "sync",
+		Configuration: []Configuration{
+			{Key: "source", Type: "elementInstance", Required: true},
+			{Key: "target", Type: "elementInstance", Required: true},
+			{Key: "archive", Type: "elementInstance"},
+			{Key: "batchSize", Type: "value", Required: true},
+			{Key: "label", Type: "value"},
+		},
+	}
+	lookup := func(id string) (ElementInstance, bool, error) {
+		switch id {
+		case "1":
+			return ElementInstance{ID: 1, Name: "sfdc", Valid: true}, true, nil
+		case "2":
+			return ElementInstance{ID: 2, Name: "hubspot", Valid: true, Disabled: true}, true, nil
+		}
+		return ElementInstance{}, false, nil
+	}
+
+	config := FormulaInstanceConfig{
+		Name: "sync instance",
+		Configuration: map[string]interface{}{
+			"source":  1,
+			"target":  "2",
+			"archive": "3",
+			"extra":   "x",
+		},
+	}
+	problems, err := ValidateFormulaInstanceConfig(f, config, lookup)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	sort.Strings(got)
+	expected := []string{
+		"archive: element instance 3 does not exist",
+		"batchSize: required value is missing",
+		`extra: not a configuration key of formula "sync"`,
+		"target: element instance 2 (hubspot) is disabled",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected problems %v", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got[i])
+		}
+	}
+
+	config = FormulaInstanceConfig{
+		Name:          "sync instance",
+		Configuration: map[string]string{"source": "1", "target": "1", "batchSize": "100", "zeta": "z", "alpha": "a", "mid": "m"},
+	}
+	problems, err = ValidateFormulaInstanceConfig(f, config, lookup)
+	if err != nil || len(problems) != 3 || problems[0].Key != "alpha" || problems[1].Key != "mid" || problems[2].Key != "zeta" {
+		t.Errorf("expected undeclared keys in order, got %v %v", problems, err)
+	}
+
+	config = FormulaInstanceConfig{
+		Name:          "sync instance",
+		Configuration: map[string]string{"source": "1", "target": "1", "batchSize": "100"},
+	}
+	problems, err = ValidateFormulaInstanceConfig(f, config, lookup)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("expected valid configuration, got %v %v", problems, err)
+	}
+}
+
+func TestSetFormulaInstancesActive(t *testing.T) {
+	var puts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/formulas/10/instances":
+			w.Write([]byte(`[{"id":5,"name":"a","active":true},{"id":6,"name":"b","active":false}]`))
+		case r.Method == "GET" && r.URL.Path == "/formulas/instances/5":
+			// the formula is not part of every response
+			w.Write([]byte(`{"id":5,"name":"a","active":true,"configuration":{"source":"1"}}`))
+		case r.Method == "PUT" && r.URL.Path == "/formulas/10/instances/5":
+			var request map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&request)
+			puts = append(puts, fmt.Sprintf("%v %v %v", request["name"], request["active"], request["configuration"]))
+			request["id"] = 5
+			json.NewEncoder(w).Encode(request)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	results, err := SetFormulaInstancesActive(ts.URL, auth, 10, false)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(puts) != 1 || puts[0] != "a false map[source:1]" {
+		t.Errorf("unexpected updates %v", puts)
+	}
+	if len(results) != 2 || !results[0].Changed || results[0].Active || results[1].Changed {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	fi, err := RenameFormulaInstance(ts.URL, auth, 10, "5", "renamed")
+	if err != nil || fi.Name != "renamed" || !fi.Active {
+		t.Errorf("unexpected rename %+v %v", fi, err)
+	}
+}
@@ -0,0 +1,255 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Lint severities, in addition to SeverityWarning
+const (
+	SeverityError = "error"
+	SeverityInfo  = "info"
+)
+
+// Lint diagnostic codes
+const (
+	LintMissingTrigger      = "missing-trigger"
+	LintMultipleTriggers    = "multiple-triggers"
+	LintDuplicateStep       = "duplicate-step"
+	LintUnknownStep         = "unknown-step"
+	LintUnreachableStep     = "unreachable-step"
+	LintCycle               = "cycle"
+	LintUnusedConfiguration = "unused-configuration"
+	LintManualWithoutAPI    = "manual-without-api"
+)
+
+// LintDiagnostic is a problem found in a Formula definition
+type LintDiagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Step     string `json:"step,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (d LintDiagnostic) String() string {
+	if d.Step != "" {
+		return fmt.Sprintf("%s: %s [%s] %s", d.Severity, d.Step, d.Code, d.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", d.Severity, d.Code, d.Message)
+}
+
+// LintHasErrors returns true if any diagnostic is an error
+func LintHasErrors(diagnostics []LintDiagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// LintFormula checks a Formula's trigger and step graph before it is sent
+// with ImportFormula or FormulaUpdate; errors will be rejected or fail at
+// runtime on the Platform, warnings are likely mistakes
+func LintFormula(f Formula) []LintDiagnostic {
+	var diagnostics []LintDiagnostic
+	report := func(severity, code, step, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, LintDiagnostic{
+			Severity: severity,
+			Code:     code,
+			Step:     step,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	// triggers
+	switch len(f.Triggers) {
+	case 0:
+		report(SeverityError, LintMissingTrigger, "", "formula %q has no trigger", f.Name)
+	case 1:
+	default:
+		report(SeverityError, LintMultipleTriggers, "", "formula %q has %v triggers, only one is allowed", f.Name, len(f.Triggers))
+	}
+
+	// step names
+	steps := make(map[string]Step)
+	for _, s := range f.Steps {
+		if _, ok := steps[s.Name]; ok {
+			report(SeverityError, LintDuplicateStep, s.Name, "step name is used more than once")
+			continue
+		}
+		steps[s.Name] = s
+	}
+
+	// references
+	checkRefs := func(from, edge string, refs []string) {
+		for _, r := range refs {
+			if _, ok := steps[r]; !ok {
+				report(SeverityError, LintUnknownStep, from, "%s refers to nonexistent step %q", edge, r)
+			}
+		}
+	}
+	for _, t := range f.Triggers {
+		name := fmt.Sprintf("trigger %s", t.Type)
+		checkRefs(name, "onSuccess", t.OnSuccess)
+		checkRefs(name, "onFailure", t.OnFailure)
+	}
+	for _, s := range f.Steps {
+		checkRefs(s.Name, "onSuccess", s.OnSuccess)
+		checkRefs(s.Name, "onFailure", s.OnFailure)
+	}
+
+	// reachability from the trigger(s)
+	if len(f.Triggers) > 0 {
+		reached := make(map[string]bool)
+		var queue []string
+		for _, t := range f.Triggers {
+			queue = append(queue, t.OnSuccess...)
+			queue = append(queue, t.OnFailure...)
+		}
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			s, ok := steps[name]
+			if !ok || reached[name] {
+				continue
+			}
+			reached[name] = true
+			queue = append(queue, s.OnSuccess...)
+			queue = append(queue, s.OnFailure...)
+		}
+		for _, s := range f.Steps {
+			if !reached[s.Name] {
+				report(SeverityWarning, LintUnreachableStep, s.Name, "step is not reachable from the trigger")
+			}
+		}
+	}
+
+	// cycles; a loop step legitimately has its body lead back to it
+	for _, cycle := range formulaCycles(f.Steps, steps) {
+		loop := false
+		for _, name := range cycle {
+			if steps[name].Type == "loop" {
+				loop = true
+			}
+		}
+		if !loop {
+			report(SeverityWarning, LintCycle, cycle[0], "steps form a cycle without a loop step: %s", strings.Join(append(cycle, cycle[0]), " -> "))
+		}
+	}
+
+	// configuration
+	var referenced bytes.Buffer
+	for _, s := range f.Steps {
+		b, _ := json.Marshal(s.Properties)
+		referenced.Write(b)
+	}
+	for _, t := range f.Triggers {
+		b, _ := json.Marshal(t.Properties)
+		referenced.Write(b)
+	}
+	for _, c := range f.Configuration {
+		if c.Required && !configReferenced(referenced.String(), c.Key) {
+			report(SeverityWarning, LintUnusedConfiguration, "", "required configuration %q is never referenced", c.Key)
+		}
+	}
+
+	// manual formulas are invoked through their API
+	for _, t := range f.Triggers {
+		if t.Type == "manual" && f.API == "" {
+			report(SeverityWarning, LintManualWithoutAPI, "", "formula %q has a manual trigger but no API", f.Name)
+		}
+	}
+
+	return diagnostics
+}
+
+// configReferencePattern matches config.key and config['key'] in JSON-encoded
+// properties; config must start an identifier, so myconfig.key and
+// trigger.config.key are not references. Submatches are the preceding
+// character (or JSON escape), the dotted key and the bracketed key.
+var configReferencePattern = regexp.MustCompile(`(^|[^A-Za-z0-9_$.\\]|\\[nrt])config(?:\.([A-Za-z0-9_$-]+)|\[\\?['"]([^'"\\]+)\\?['"]\])`)
+
+// configReferences returns the configuration keys referenced in a JSON string
+func configReferences(s string) []string {
+	var keys []string
+	for _, m := range configReferencePattern.FindAllStringSubmatch(s, -1) {
+		if m[2] != "" {
+			keys = append(keys, m[2])
+		} else {
+			keys = append(keys, m[3])
+		}
+	}
+	return keys
+}
+
+// configReferenced checks JSON-encoded properties for a reference to the
+// configuration key, in the forms ${config.key}, config.key or config['key']
+func configReferenced(properties, key string) bool {
+	for _, k := range configReferences(properties) {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// formulaCycles returns each cycle in the step graph once, as a list of step
+// names starting at the first step (in Steps order) on the cycle
+func formulaCycles(order []Step, steps map[string]Step) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+	seen := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		s := steps[name]
+		for _, next := range append(append([]string{}, s.OnSuccess...), s.OnFailure...) {
+			if _, ok := steps[next]; !ok {
+				continue
+			}
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == next {
+						cycle := append([]string{}, stack[i:]...)
+						key := cycleKey(cycle)
+						if !seen[key] {
+							seen[key] = true
+							cycles = append(cycles, cycle)
+						}
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, s := range order {
+		if state[s.Name] == unvisited {
+			visit(s.Name)
+		}
+	}
+	return cycles
+}
+
+func cycleKey(cycle []string) string {
+	sorted := append([]string{}, cycle...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\x00")
+}
@@ -0,0 +1,93 @@
+package ce
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintFormula(t *testing.T) {
+	f := Formula{
+		Name: "lint",
+		Triggers: []Trigger{
+			{Type: "manual", OnSuccess: []string{"start"}},
+		},
+		Steps: []Step{
+			{Name: "start", Type: "script", OnSuccess: []string{"loop"}, OnFailure: []string{"missing"}},
+			{Name: "loop", Type: "loop", OnSuccess: []string{"body"}, OnFailure: []string{"a"}},
+			{Name: "body", Type: "script", OnSuccess: []string{"loop"}, Properties: map[string]string{"body": "done(config.used);"}},
+			{Name: "a", Type: "script", OnSuccess: []string{"b"}},
+			{Name: "b", Type: "script", OnSuccess: []string{"a"}},
+			{Name: "orphan", Type: "script"},
+			{Name: "orphan", Type: "script"},
+		},
+		Configuration: []Configuration{
+			{Key: "used", Required: true},
+			{Key: "unused", Required: true},
+			{Key: "optional"},
+		},
+	}
+	diagnostics := LintFormula(f)
+
+	expected := map[string]string{
+		LintUnknownStep:         "start",
+		LintDuplicateStep:       "orphan",
+		LintUnreachableStep:     "orphan",
+		LintCycle:               "a",
+		LintUnusedConfiguration: "",
+		LintManualWithoutAPI:    "",
+	}
+	found := make(map[string]int)
+	for _, d := range diagnostics {
+		found[d.Code]++
+		step, ok := expected[d.Code]
+		if !ok {
+			t.Errorf("unexpected diagnostic %s", d)
+			continue
+		}
+		if d.Step != step {
+			t.Errorf("expected %s on step %q, got %s", d.Code, step, d)
+		}
+	}
+	for code := range expected {
+		if found[code] == 0 {
+			t.Errorf("missing diagnostic %s", code)
+		}
+	}
+	if found[LintUnreachableStep] != 2 || found[LintCycle] != 1 || found[LintUnusedConfiguration] != 1 {
+		t.Errorf("unexpected counts %v", found)
+	}
+	if !LintHasErrors(diagnostics) {
+		t.Errorf("expected errors")
+	}
+
+	missing := LintFormula(Formula{Name: "empty"})
+	if len(missing) != 1 || missing[0].Code != LintMissingTrigger {
+		t.Errorf("unexpected diagnostics %v", missing)
+	}
+}
+
+func TestLintFormulaConfigurationPrefix(t *testing.T) {
+	f := Formula{
+		Name:     "prefix",
+		Triggers: []Trigger{{Type: "scheduled", OnSuccess: []string{"start"}}},
+		Steps: []Step{
+			{Name: "start", Type: "script", Properties: map[string]string{"body": "done(${config.crmBackup});"}},
+		},
+		Configuration: []Configuration{
+			{Key: "crm", Required: true},
+			{Key: "crmBackup", Required: true},
+		},
+	}
+	diagnostics := LintFormula(f)
+	if len(diagnostics) != 1 || diagnostics[0].Code != LintUnusedConfiguration || !strings.Contains(diagnostics[0].Message, `"crm"`) {
+		t.Errorf("unexpected diagnostics %v", diagnostics)
+	}
+}
+
+func TestConfigReferences(t *testing.T) {
+	properties := `{"body":"var a = config.crm;\nconfig['target'];\n\tmyconfig.other; trigger.config.event; steps.fooconfig['b']; $config.c","id":"${config.instance}"}`
+	got := configReferences(properties)
+	if strings.Join(got, ",") != "crm,target,instance" {
+		t.Errorf("unexpected references %v", got)
+	}
+}
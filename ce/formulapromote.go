@@ -0,0 +1,268 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Promotion actions
+const (
+	PromoteCreated   = "created"
+	PromoteUpdated   = "updated"
+	PromoteUnchanged = "unchanged"
+)
+
+// PromoteOptions controls how a Formula is promoted to another environment
+type PromoteOptions struct {
+	// InstanceIDs maps source Element Instance IDs to target Element Instance
+	// IDs in Element Instance ID properties and ${<id>} values of steps and
+	// triggers
+	InstanceIDs map[string]string
+	// FormulaIDs maps source Formula IDs to target Formula IDs in the
+	// formulaId of formula steps
+	FormulaIDs map[string]string
+	// DryRun reports what would change without creating or updating the target
+	DryRun bool
+	Debug  bool
+}
+
+// PromoteResult reports what a promotion changed in the target environment
+type PromoteResult struct {
+	Name     string      `json:"name"`
+	Action   string      `json:"action"`
+	SourceID int         `json:"sourceId"`
+	TargetID int         `json:"targetId,omitempty"`
+	DryRun   bool        `json:"dryRun"`
+	Remapped []string    `json:"remapped,omitempty"`
+	Diff     FormulaDiff `json:"diff"`
+}
+
+// PromoteFormula copies a Formula from a source environment to a target
+// environment, matching the target Formula by name: environment-specific IDs
+// are stripped, Element Instance IDs are remapped, and the target is created
+// or updated
+func PromoteFormula(sourceBase, sourceAuth, targetBase, targetAuth string, formulaID string, opts PromoteOptions) (PromoteResult, error) {
+	var result PromoteResult
+	result.DryRun = opts.DryRun
+
+	source, err := getFormula(sourceBase, sourceAuth, formulaID)
+	if err != nil {
+		return result, err
+	}
+	result.Name = source.Name
+	result.SourceID = source.ID
+
+	promoted := StripFormulaIDs(source)
+	result.Remapped = RemapInstanceIDs(&promoted, opts.InstanceIDs)
+	result.Remapped = append(result.Remapped, RemapFormulaIDs(&promoted, opts.FormulaIDs)...)
+
+	// find the Formula in the target by name
+	bodybytes, status, _, err := FormulasList(targetBase, targetAuth)
+	if err != nil {
+		return result, err
+	}
+	if status != 200 {
+		return result, fmt.Errorf("Unable to list target formulas, status code %v", status)
+	}
+	var formulas []Formula
+	err = json.Unmarshal(bodybytes, &formulas)
+	if err != nil {
+		return result, err
+	}
+	var matches []Formula
+	for _, f := range formulas {
+		if f.Name == source.Name {
+			matches = append(matches, f)
+		}
+	}
+	if len(matches) > 1 {
+		return result, fmt.Errorf("target has %v formulas named %q", len(matches), source.Name)
+	}
+
+	if len(matches) == 0 {
+		result.Action = PromoteCreated
+		result.Diff = DiffFormulas(Formula{}, promoted)
+		if opts.Debug {
+			log.Printf("Creating formula %s in target", source.Name)
+		}
+		if opts.DryRun {
+			return result, nil
+		}
+		bodybytes, status, _, err = ImportFormula(targetBase, targetAuth, promoted)
+		if err != nil {
+			return result, err
+		}
+		if status != 200 {
+			return result, fmt.Errorf("Unable to create target formula, status code %v: %s", status, bodybytes)
+		}
+		var created Formula
+		err = json.Unmarshal(bodybytes, &created)
+		if err != nil {
+			return result, err
+		}
+		result.TargetID = created.ID
+		return result, nil
+	}
+
+	target, err := getFormula(targetBase, targetAuth, strconv.Itoa(matches[0].ID))
+	if err != nil {
+		return result, err
+	}
+	result.TargetID = target.ID
+	result.Diff = DiffFormulas(StripFormulaIDs(target), promoted)
+	if result.Diff.Empty() {
+		result.Action = PromoteUnchanged
+		return result, nil
+	}
+	result.Action = PromoteUpdated
+	if opts.Debug {
+		log.Printf("Updating formula %s (%v) in target with %v changes", target.Name, target.ID, len(result.Diff.Changes))
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+	bodybytes, status, err = FormulaUpdate(strconv.Itoa(target.ID), targetBase, targetAuth, promoted)
+	if err != nil {
+		return result, err
+	}
+	if status != 200 {
+		return result, fmt.Errorf("Unable to update target formula, status code %v: %s", status, bodybytes)
+	}
+	return result, nil
+}
+
+// getFormula retrieves and decodes a Formula
+func getFormula(base, auth string, formulaID string) (Formula, error) {
+	var f Formula
+	bodybytes, status, _, err := FormulaDetailsAsBytes(formulaID, base, auth)
+	if err != nil {
+		return f, err
+	}
+	if status != 200 {
+		return f, fmt.Errorf("Unable to retrieve formula %s, status code %v", formulaID, status)
+	}
+	err = json.Unmarshal(bodybytes, &f)
+	return f, err
+}
+
+// RemapInstanceIDs replaces Element Instance IDs in step and trigger
+// properties using the source to target map, returning a description of each
+// replacement; string and numeric IDs are replaced in properties named like
+// elementInstanceId, and ${<id>} references anywhere
+func RemapInstanceIDs(f *Formula, ids map[string]string) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	var remapped []string
+	for i, s := range f.Steps {
+		var changed []string
+		f.Steps[i].Properties, changed = remapValue(normalizeJSON(s.Properties), "properties", false, ids)
+		for _, c := range changed {
+			remapped = append(remapped, fmt.Sprintf("step %s %s", s.Name, c))
+		}
+	}
+	for i, t := range f.Triggers {
+		var changed []string
+		f.Triggers[i].Properties, changed = remapValue(normalizeJSON(t.Properties), "properties", false, ids)
+		for _, c := range changed {
+			remapped = append(remapped, fmt.Sprintf("trigger %v %s", i, c))
+		}
+	}
+	return remapped
+}
+
+// RemapFormulaIDs replaces the formulaId of formula steps using the source to
+// target map, returning a description of each replacement
+func RemapFormulaIDs(f *Formula, ids map[string]string) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	var remapped []string
+	for i, s := range f.Steps {
+		if s.Type != StepTypeFormula {
+			continue
+		}
+		properties, ok := normalizeJSON(s.Properties).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		to, desc, ok := remapID(properties["formulaId"], ids)
+		if !ok {
+			continue
+		}
+		remapped = append(remapped, fmt.Sprintf("step %s properties.formulaId: %s", s.Name, desc))
+		properties["formulaId"] = to
+		f.Steps[i].Properties = properties
+	}
+	return remapped
+}
+
+// instanceIDPattern is a reference to an Element Instance by ID
+var instanceIDPattern = regexp.MustCompile(`^\$\{(\d+)\}$`)
+
+// instanceIDKey reports whether a property holds Element Instance IDs, such as
+// elementInstanceId or downloadElementInstanceId
+func instanceIDKey(key string) bool {
+	k := strings.ToLower(key)
+	return strings.HasSuffix(k, "elementinstanceid") || strings.HasSuffix(k, "elementinstanceids")
+}
+
+// remapValue replaces IDs under Element Instance ID properties, and ${<id>}
+// references in any string, in a normalized JSON value
+func remapValue(v interface{}, path string, idKey bool, ids map[string]string) (interface{}, []string) {
+	var changed []string
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			var c []string
+			t[k], c = remapValue(child, joinPath(path, k), instanceIDKey(k), ids)
+			changed = append(changed, c...)
+		}
+	case []interface{}:
+		for i, child := range t {
+			var c []string
+			t[i], c = remapValue(child, fmt.Sprintf("%s[%v]", path, i), idKey, ids)
+			changed = append(changed, c...)
+		}
+	case string:
+		if m := instanceIDPattern.FindStringSubmatch(t); m != nil {
+			if to, ok := ids[m[1]]; ok {
+				to = "${" + to + "}"
+				return to, []string{fmt.Sprintf("%s: %s -> %s", path, t, to)}
+			}
+		}
+		if to, desc, ok := remapID(t, ids); idKey && ok {
+			return to, []string{path + ": " + desc}
+		}
+	case float64:
+		if to, desc, ok := remapID(t, ids); idKey && ok {
+			return to, []string{path + ": " + desc}
+		}
+	}
+	sort.Strings(changed)
+	return v, changed
+}
+
+// remapID maps a string or numeric ID, keeping its JSON type, and describes
+// the replacement
+func remapID(v interface{}, ids map[string]string) (interface{}, string, bool) {
+	switch t := v.(type) {
+	case string:
+		if to, ok := ids[t]; ok {
+			return to, t + " -> " + to, true
+		}
+	case float64:
+		from := strconv.FormatFloat(t, 'f', -1, 64)
+		if to, ok := ids[from]; ok {
+			if n, err := strconv.ParseFloat(to, 64); err == nil {
+				return n, from + " -> " + to, true
+			}
+		}
+	}
+	return v, "", false
+}
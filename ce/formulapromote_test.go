@@ -0,0 +1,97 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPromoteFormula(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":100,"name":"sync","accountId":1,"active":true,
+			"triggers":[{"id":7,"type":"event","onSuccess":["get"],"properties":{"elementInstanceId":"111"}}],
+			"steps":[{"id":8,"name":"get","type":"elementRequest","properties":{"elementInstanceId":111,"api":"/hubs/crm/contacts"}}]}`)
+	}))
+	defer source.Close()
+
+	var patched Formula
+	var methods []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "GET" && r.URL.Path == FormulasURI:
+			fmt.Fprint(w, `[{"id":200,"name":"sync"},{"id":201,"name":"other"}]`)
+		case r.Method == "GET":
+			fmt.Fprint(w, `{"id":200,"name":"sync","accountId":2,"active":false,
+				"triggers":[{"id":9,"type":"event","onSuccess":["get"],"properties":{"elementInstanceId":"222"}}],
+				"steps":[{"id":10,"name":"get","type":"elementRequest","properties":{"elementInstanceId":222,"api":"/hubs/crm/contacts"}}]}`)
+		case r.Method == "PATCH":
+			json.NewDecoder(r.Body).Decode(&patched)
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer target.Close()
+
+	opts := PromoteOptions{InstanceIDs: map[string]string{"111": "222"}}
+	result, err := PromoteFormula(source.URL, auth, target.URL, auth, "100", opts)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if result.Action != PromoteUpdated || result.TargetID != 200 || len(result.Remapped) != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	// only the active flag differs once instance IDs are remapped
+	if len(result.Diff.Changes) != 1 || result.Diff.Changes[0].Path != "active" {
+		t.Errorf("unexpected diff %s", result.Diff)
+	}
+	if methods[len(methods)-1] != "PATCH /formulas/200" || !patched.Active || patched.Steps[0].ID != 0 {
+		t.Errorf("unexpected update %v %+v", methods, patched)
+	}
+
+	methods = nil
+	opts.DryRun = true
+	result, err = PromoteFormula(source.URL, auth, target.URL, auth, "100", opts)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	for _, m := range methods {
+		if m[:3] != "GET" {
+			t.Errorf("dry run sent %s", m)
+		}
+	}
+}
+
+func TestRemapIDs(t *testing.T) {
+	f := Formula{
+		Triggers: []Trigger{{Type: "event", Properties: map[string]interface{}{"elementInstanceId": "111"}}},
+		Steps: []Step{
+			{Name: "get", Type: "elementRequest", Properties: map[string]interface{}{
+				"elementInstanceId": 111,
+				"pageSize":          111,
+				"api":               "/hubs/crm/contacts/111",
+				"query":             map[string]interface{}{"limit": "111", "instance": "${111}"},
+			}},
+			{Name: "sub", Type: StepTypeFormula, Properties: map[string]interface{}{"formulaId": 300}},
+		},
+	}
+	remapped := RemapInstanceIDs(&f, map[string]string{"111": "222", "300": "999"})
+	remapped = append(remapped, RemapFormulaIDs(&f, map[string]string{"300": "400", "111": "999"})...)
+	if len(remapped) != 4 {
+		t.Errorf("unexpected remapped %v", remapped)
+	}
+	trigger := f.Triggers[0].Properties.(map[string]interface{})
+	get := f.Steps[0].Properties.(map[string]interface{})
+	query := get["query"].(map[string]interface{})
+	sub := f.Steps[1].Properties.(map[string]interface{})
+	if trigger["elementInstanceId"] != "222" || get["elementInstanceId"] != float64(222) || query["instance"] != "${222}" {
+		t.Errorf("instance IDs not remapped: %v %v", trigger, get)
+	}
+	if get["pageSize"] != float64(111) || get["api"] != "/hubs/crm/contacts/111" || query["limit"] != "111" {
+		t.Errorf("unexpected remapping of other values: %v", get)
+	}
+	if sub["formulaId"] != float64(400) {
+		t.Errorf("formula ID not remapped: %v", sub)
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/moul/http2curl"
@@ -32,8 +34,12 @@ const (
 	FormulaInstancesURIFormat = "/formulas/%s/instances"
 	// FormulaInstanceDetailsURIFormat is the URL format to get the details of a Formula Instance
 	FormulaInstanceDetailsURIFormat = "/formulas/instances/%s"
+	// FormulaInstanceURIFormat is the URI of a Formula Instance, given a Formula ID and Instance ID
+	FormulaInstanceURIFormat = "/formulas/%v/instances/%s"
 	// FormulaInstanceDeleteURIFormat is the URI to delete a Formula Instance
-	FormulaInstanceDeleteURIFormat = "/formulas/%v/instances/%s"
+	FormulaInstanceDeleteURIFormat = FormulaInstanceURIFormat
+	// NextPageTokenHeader is the response header holding the token of the next page of results
+	NextPageTokenHeader = "Elements-Next-Page-Token"
 )
 
 // Formula represents the structure of a CE Formula
@@ -121,6 +127,57 @@ type FormulaInstanceExecution struct {
 	UpdatedDate       time.Time `json:"updatedDate"`
 }
 
+// StripFormulaIDs returns a copy of a Formula without the IDs, owner and
+// instances assigned by the Platform, suitable for ImportFormula into any account
+func StripFormulaIDs(f Formula) Formula {
+	f.ID = 0
+	f.UserID = 0
+	f.AccountID = 0
+	f.CreatedDate = time.Time{}
+	f.Instances = nil
+	steps := make([]Step, len(f.Steps))
+	for i, s := range f.Steps {
+		s.ID = 0
+		steps[i] = s
+	}
+	f.Steps = steps
+	triggers := make([]Trigger, len(f.Triggers))
+	for i, t := range f.Triggers {
+		t.ID = 0
+		triggers[i] = t
+	}
+	f.Triggers = triggers
+	configuration := make([]Configuration, len(f.Configuration))
+	for i, c := range f.Configuration {
+		c.ID = 0
+		configuration[i] = c
+	}
+	f.Configuration = configuration
+	return f
+}
+
+// ConfigurationValues returns the Formula Instance's configuration as strings,
+// keyed by the Formula's Configuration keys
+func (fi FormulaInstance) ConfigurationValues() map[string]string {
+	values := make(map[string]string)
+	config, ok := fi.Configuration.(map[string]interface{})
+	if !ok {
+		return values
+	}
+	for k, v := range config {
+		switch t := v.(type) {
+		case string:
+			values[k] = t
+		case float64:
+			values[k] = strconv.FormatFloat(t, 'f', -1, 64)
+		case nil:
+		default:
+			values[k] = fmt.Sprintf("%v", t)
+		}
+	}
+	return values
+}
+
 // GetFormulaInstances returns the Formula Instances associated a Formula Template ID
 func GetFormulaInstances(base, auth string, formulaID string) ([]byte, int, string, error) {
 	var bodybytes []byte
@@ -244,6 +301,15 @@ func CancelFormulaExecution(base, auth string, executionID string) ([]byte, int,
 	return bodybytes, resp.StatusCode, curl, nil
 }
 
+// RetryFormulaExecution retries a Formula Instance Execution given an Execution ID
+func RetryFormulaExecution(base, auth string, executionID string) ([]byte, int, string, error) {
+	url := fmt.Sprintf("%s%s",
+		base,
+		fmt.Sprintf(FormulaRetryExecutionURI, executionID),
+	)
+	return ExecuteWithBody("POST", url, auth, []byte("{}"))
+}
+
 // GetFormulaInstanceExecutions returns a list of Formula Instance Executions given a Formula Instance ID
 func GetFormulaInstanceExecutions(base, auth string, formulaInstanceID string) ([]byte, int, string, error) {
 	var bodybytes []byte
@@ -271,6 +337,96 @@ func GetFormulaInstanceExecutions(base, auth string, formulaInstanceID string) (
 	return bodybytes, resp.StatusCode, curl, nil
 }
 
+// GetFormulaInstanceExecutionsList returns the Executions of a Formula Instance
+func GetFormulaInstanceExecutionsList(base, auth string, formulaInstanceID string) ([]FormulaInstanceExecution, error) {
+	var executions []FormulaInstanceExecution
+	bodybytes, status, _, err := GetFormulaInstanceExecutions(base, auth, formulaInstanceID)
+	if err != nil {
+		return executions, err
+	}
+	if status != 200 {
+		return executions, fmt.Errorf("Status code %v", status)
+	}
+	err = json.Unmarshal(bodybytes, &executions)
+	if err != nil {
+		return executions, err
+	}
+	return executions, nil
+}
+
+// GetFormulaInstanceExecutionsPage returns a page of the Executions of a
+// Formula Instance and the token for the next page, empty on the last page
+func GetFormulaInstanceExecutionsPage(base, auth string, formulaInstanceID string, pageSize int, nextPage string) ([]FormulaInstanceExecution, string, error) {
+	var executions []FormulaInstanceExecution
+	q := url.Values{}
+	if pageSize > 0 {
+		q.Set("pageSize", strconv.Itoa(pageSize))
+	}
+	if nextPage != "" {
+		q.Set("nextPage", nextPage)
+	}
+	u := fmt.Sprintf("%s%s", base, fmt.Sprintf(FormulaExecutionsURIFormat, formulaInstanceID))
+	if len(q) > 0 {
+		u = u + "?" + q.Encode()
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return executions, "", err
+	}
+	req.Header.Add("Authorization", auth)
+	req.Header.Add("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return executions, "", err
+	}
+	defer resp.Body.Close()
+	bodybytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return executions, "", err
+	}
+	if resp.StatusCode != 200 {
+		return executions, "", fmt.Errorf("Status code %v", resp.StatusCode)
+	}
+	err = json.Unmarshal(bodybytes, &executions)
+	return executions, resp.Header.Get(NextPageTokenHeader), err
+}
+
+// DefaultExecutionsPageSize is the page size used by
+// EachFormulaInstanceExecutionsPage when none is given
+const DefaultExecutionsPageSize = 200
+
+// EachFormulaInstanceExecutionsPage pages through the Executions of a Formula
+// Instance, newest first, calling fn with each page until fn returns false or
+// the last page has been read
+func EachFormulaInstanceExecutionsPage(base, auth string, formulaInstanceID string, pageSize int, fn func([]FormulaInstanceExecution) bool) error {
+	if pageSize <= 0 {
+		pageSize = DefaultExecutionsPageSize
+	}
+	nextPage := ""
+	for {
+		page, token, err := GetFormulaInstanceExecutionsPage(base, auth, formulaInstanceID, pageSize, nextPage)
+		if err != nil {
+			return err
+		}
+		if !fn(page) || token == "" || token == nextPage || len(page) == 0 {
+			return nil
+		}
+		nextPage = token
+	}
+}
+
+// GetAllFormulaInstanceExecutions returns every Execution of a Formula
+// Instance, newest first, reading all pages
+func GetAllFormulaInstanceExecutions(base, auth string, formulaInstanceID string) ([]FormulaInstanceExecution, error) {
+	var executions []FormulaInstanceExecution
+	err := EachFormulaInstanceExecutionsPage(base, auth, formulaInstanceID, 0, func(page []FormulaInstanceExecution) bool {
+		executions = append(executions, page...)
+		return true
+	})
+	return executions, err
+}
+
 // TriggerFormulaInstance invokes a Formula Instance with the given trigger
 func TriggerFormulaInstance(base, auth string, formulaTemplateID, triggerBody string) ([]byte, int, string, error) {
 	var bodybytes []byte
@@ -551,7 +707,7 @@ func FormulaDetailsAsBytes(formulaID, base, auth string) ([]byte, int, string, e
 	curl := fmt.Sprintf("%s", curlCmd)
 	resp, err := client.Do(req)
 	if err != nil {
-		return bodybytes, resp.StatusCode, curl, err
+		return bodybytes, -1, curl, err
 
 	}
 	bodybytes, err = ioutil.ReadAll(resp.Body)
@@ -661,27 +817,33 @@ func GetFormulaInstanceExecutionID(executionID, base, auth string) ([]byte, int,
 	return bodybytes, resp.StatusCode, curl, nil
 }
 
-// CombinedFormulaAndInstances returns a list of Formulas with Instances
+// CombinedFormulaAndInstances returns a list of Formulas with Instances;
+// Formulas without a trigger are returned without Instances, and Formulas
+// whose Instances cannot be retrieved are reported in the error
 func CombinedFormulaAndInstances(formulabytes []byte, base, auth string) ([]Formula, error) {
 	var formulas []Formula
 	err := json.Unmarshal(formulabytes, &formulas)
 	if err != nil {
 		return formulas, err
 	}
+	var failed []string
 	for i, v := range formulas {
 		if len(v.Triggers) < 1 {
 			log.Printf("Formula %v is malformed, no trigger present\n", v.ID)
-			break
+			continue
 		}
 		instances, err := GetInstancesOfFormula(v.ID, base, auth)
 		if err != nil {
-			break
+			failed = append(failed, fmt.Sprintf("formula %v: %s", v.ID, err))
+			continue
 		}
 		// note use of index here, since range makes a copy of slice
 		// https://golang.org/ref/spec#RangeClause
 		formulas[i].Instances = instances
 	}
-
+	if len(failed) > 0 {
+		return formulas, fmt.Errorf("unable to retrieve instances of %s", strings.Join(failed, "; "))
+	}
 	return formulas, nil
 }
 
@@ -1,6 +1,9 @@
 package ce
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 )
@@ -110,3 +113,121 @@ func TestGetFormulaInstances(t *testing.T) {
 	}
 }
 */
+
+func TestCombinedFormulaAndInstances(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/formulas/2/instances":
+			w.Write([]byte(`[{"id":20,"name":"two"}]`))
+		case "/formulas/3/instances":
+			w.WriteHeader(500)
+			w.Write([]byte(`{"message":"unavailable"}`))
+		case "/formulas/4/instances":
+			w.Write([]byte(`[{"id":40,"name":"four"}]`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	// a Formula without triggers first must not stop the others
+	formulabytes := []byte(`[
+		{"id":1,"name":"untriggered"},
+		{"id":2,"name":"two","triggers":[{"type":"manual"}]},
+		{"id":4,"name":"four","triggers":[{"type":"manual"}]}
+	]`)
+	formulas, err := CombinedFormulaAndInstances(formulabytes, ts.URL, auth)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(formulas) != 3 || len(formulas[0].Instances) != 0 || len(formulas[1].Instances) != 1 || len(formulas[2].Instances) != 1 || formulas[2].Instances[0].ID != 40 {
+		t.Errorf("unexpected formulas %+v", formulas)
+	}
+
+	formulabytes = []byte(`[
+		{"id":3,"name":"three","triggers":[{"type":"manual"}]},
+		{"id":4,"name":"four","triggers":[{"type":"manual"}]}
+	]`)
+	formulas, err = CombinedFormulaAndInstances(formulabytes, ts.URL, auth)
+	if err == nil {
+		t.Errorf("expected an error for formula 3")
+	}
+	if len(formulas) != 2 || len(formulas[1].Instances) != 1 {
+		t.Errorf("unexpected formulas %+v", formulas)
+	}
+}
+
+// pagedExecutionsServer serves executions, newest first, as the executions of
+// the Formula Instance in pages of size, ignoring the requested page size
+func pagedExecutionsServer(t *testing.T, formulaInstanceID string, executions []FormulaInstanceExecution, size int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/formulas/instances/"+formulaInstanceID+"/executions" {
+			w.WriteHeader(404)
+			return
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("nextPage"))
+		end := start + size
+		if end >= len(executions) {
+			end = len(executions)
+		} else {
+			w.Header().Set(NextPageTokenHeader, strconv.Itoa(end))
+		}
+		if start > end {
+			start = end
+		}
+		json.NewEncoder(w).Encode(executions[start:end])
+	}))
+}
+
+func TestEachFormulaInstanceExecutionsPage(t *testing.T) {
+	var executions []FormulaInstanceExecution
+	for i := 7; i > 0; i-- {
+		executions = append(executions, FormulaInstanceExecution{ID: i})
+	}
+	ts := pagedExecutionsServer(t, "4", executions, 3)
+	defer ts.Close()
+
+	all, err := GetAllFormulaInstanceExecutions(ts.URL, auth, "4")
+	if err != nil || len(all) != 7 || all[6].ID != 1 {
+		t.Errorf("expected every page, got %+v %v", all, err)
+	}
+	pages := 0
+	err = EachFormulaInstanceExecutionsPage(ts.URL, auth, "4", 0, func(page []FormulaInstanceExecution) bool {
+		pages++
+		return false
+	})
+	if err != nil || pages != 1 {
+		t.Errorf("expected paging to stop after the first page, read %v %v", pages, err)
+	}
+	if _, err = GetAllFormulaInstanceExecutions(ts.URL, auth, "5"); err == nil {
+		t.Errorf("expected error for a missing formula instance")
+	}
+}
+
+func TestUnreachableHost(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	unreachable := ts.URL
+	ts.Close()
+
+	if _, status, _, err := FormulaDetailsAsBytes("1", unreachable, auth); err == nil || status != -1 {
+		t.Errorf("expected transport error from FormulaDetailsAsBytes, got %v %v", status, err)
+	}
+	if _, status, _, err := FormulasList(unreachable, auth); err == nil || status != -1 {
+		t.Errorf("expected transport error from FormulasList, got %v %v", status, err)
+	}
+	if _, status, _, err := GetFormulaInstanceExecutionID("1", unreachable, auth); err == nil || status != -1 {
+		t.Errorf("expected transport error from GetFormulaInstanceExecutionID, got %v %v", status, err)
+	}
+	if _, status, _, err := GetElementInstances(unreachable, auth, "1"); err == nil || status != -1 {
+		t.Errorf("expected transport error from GetElementInstances, got %v %v", status, err)
+	}
+	if _, err := getFormula(unreachable, auth, "1"); err == nil {
+		t.Errorf("expected transport error from getFormula")
+	}
+	if _, err := SearchAccountFormulas(unreachable, auth, FormulaSearch{}); err == nil {
+		t.Errorf("expected transport error from SearchAccountFormulas")
+	}
+	if _, err := GetFormulaInstanceHealth(unreachable, auth); err == nil {
+		t.Errorf("expected transport error from GetFormulaInstanceHealth")
+	}
+}
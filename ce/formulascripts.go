@@ -0,0 +1,184 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ExplodedFormulaVersion is the version of the layout written by ExplodeFormula
+	ExplodedFormulaVersion = 1
+
+	explodedManifestFile = "manifest.json"
+	explodedFormulaFile  = "formula.json"
+	explodedScriptsDir   = "scripts"
+)
+
+// ExplodedScript maps a script or filter step to the file holding its JavaScript
+type ExplodedScript struct {
+	Step string `json:"step"`
+	ID   int    `json:"id,omitempty"`
+	File string `json:"file"`
+}
+
+// ExplodedManifest describes a Formula written by ExplodeFormula
+type ExplodedManifest struct {
+	Version int              `json:"version"`
+	Formula string           `json:"formula"`
+	Scripts []ExplodedScript `json:"scripts"`
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// scriptFileName returns a file name for a step's script that is unique
+// within used, ignoring case so names don't collide on case-insensitive file
+// systems
+func scriptFileName(stepName string, used map[string]bool) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(stepName, "-"), "-.")
+	if name == "" {
+		name = "step"
+	}
+	file := path.Join(explodedScriptsDir, name+".js")
+	for i := 2; used[strings.ToLower(file)]; i++ {
+		file = path.Join(explodedScriptsDir, fmt.Sprintf("%s-%v.js", name, i))
+	}
+	used[strings.ToLower(file)] = true
+	return file
+}
+
+// scriptFilePath checks that a manifest's script file is in the scripts
+// directory, so a manifest can't read files outside the exploded Formula
+func scriptFilePath(dir, file string) (string, error) {
+	name := path.Base(file)
+	if path.Dir(file) != explodedScriptsDir || unsafeFileChars.MatchString(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%q is not a file in the %s directory", file, explodedScriptsDir)
+	}
+	return filepath.Join(dir, filepath.FromSlash(file)), nil
+}
+
+// ExplodeFormula writes a Formula to a directory with the JavaScript of each
+// script and filter step in its own .js file, the rest of the Formula in
+// formula.json and the step to file mapping in manifest.json
+func ExplodeFormula(f Formula, dir string) (ExplodedManifest, error) {
+	manifest := ExplodedManifest{Version: ExplodedFormulaVersion, Formula: f.Name}
+	files := make(map[string][]byte)
+	used := make(map[string]bool)
+
+	steps := make([]Step, len(f.Steps))
+	copy(steps, f.Steps)
+	for i, s := range steps {
+		if s.Type != StepTypeScript && s.Type != StepTypeFilter {
+			continue
+		}
+		p, err := s.TypedProperties()
+		if err != nil {
+			return manifest, fmt.Errorf("step %s: %s", s.Name, err)
+		}
+		var body string
+		switch t := p.(type) {
+		case *ScriptProperties:
+			body, t.Body = t.Body, ""
+		case *FilterProperties:
+			body, t.Body = t.Body, ""
+		}
+		err = steps[i].SetProperties(p)
+		if err != nil {
+			return manifest, fmt.Errorf("step %s: %s", s.Name, err)
+		}
+		file := scriptFileName(s.Name, used)
+		files[file] = []byte(body)
+		manifest.Scripts = append(manifest.Scripts, ExplodedScript{Step: s.Name, ID: s.ID, File: file})
+	}
+	f.Steps = steps
+
+	var err error
+	files[explodedFormulaFile], err = json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	files[explodedManifestFile], err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return manifest, err
+		}
+		err = ioutil.WriteFile(p, contents, 0644)
+		if err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// ImplodeFormula reads a directory written by ExplodeFormula and returns the
+// Formula with each script file embedded back into its step, ready for
+// ImportFormula or FormulaUpdate; step order and IDs are preserved
+func ImplodeFormula(dir string) (Formula, error) {
+	var f Formula
+	var manifest ExplodedManifest
+	manifestbytes, err := ioutil.ReadFile(filepath.Join(dir, explodedManifestFile))
+	if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(manifestbytes, &manifest)
+	if err != nil {
+		return f, fmt.Errorf("%s: %s", explodedManifestFile, err)
+	}
+	if manifest.Version > ExplodedFormulaVersion {
+		return f, fmt.Errorf("exploded formula version %v is newer than supported version %v", manifest.Version, ExplodedFormulaVersion)
+	}
+	formulabytes, err := ioutil.ReadFile(filepath.Join(dir, explodedFormulaFile))
+	if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(formulabytes, &f)
+	if err != nil {
+		return f, fmt.Errorf("%s: %s", explodedFormulaFile, err)
+	}
+
+	index := make(map[string]int)
+	for i, s := range f.Steps {
+		index[s.Name] = i
+	}
+	for _, script := range manifest.Scripts {
+		i, ok := index[script.Step]
+		if !ok {
+			return f, fmt.Errorf("manifest refers to step %q which is not in %s", script.Step, explodedFormulaFile)
+		}
+		file, err := scriptFilePath(dir, script.File)
+		if err != nil {
+			return f, fmt.Errorf("step %s: %s", script.Step, err)
+		}
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return f, err
+		}
+		p, err := f.Steps[i].TypedProperties()
+		if err != nil {
+			return f, fmt.Errorf("step %s: %s", script.Step, err)
+		}
+		switch t := p.(type) {
+		case *ScriptProperties:
+			t.Body = string(body)
+		case *FilterProperties:
+			t.Body = string(body)
+		default:
+			return f, fmt.Errorf("step %s is a %s step, not a script", script.Step, f.Steps[i].Type)
+		}
+		err = f.Steps[i].SetProperties(p)
+		if err != nil {
+			return f, fmt.Errorf("step %s: %s", script.Step, err)
+		}
+	}
+	return f, nil
+}
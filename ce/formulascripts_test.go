@@ -0,0 +1,99 @@
+package ce
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExplodeImplodeFormula(t *testing.T) {
+	dir, err := ioutil.TempDir("", "explode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var f Formula
+	err = json.Unmarshal([]byte(`{
+		"id": 10, "name": "scripts",
+		"triggers": [{"type": "manual", "onSuccess": ["build payload"]}],
+		"steps": [
+			{"id": 1, "name": "build payload", "type": "script", "onSuccess": ["is valid?"], "properties": {"body": "done({a: 1});\n", "mimeType": "application/javascript"}},
+			{"id": 2, "name": "is valid?", "type": "filter", "onSuccess": ["send"], "properties": {"body": "done(true);"}},
+			{"id": 3, "name": "send", "type": "httpRequest", "properties": {"url": "https://example.com", "method": "POST"}},
+			{"id": 4, "name": "build/payload", "type": "script", "properties": {"body": "done();"}}
+		]
+	}`), &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ExplodeFormula(f, dir)
+	if err != nil {
+		t.Fatalf("explode: %s", err)
+	}
+	if len(manifest.Scripts) != 3 || manifest.Scripts[0].File != "scripts/build-payload.js" || manifest.Scripts[2].File != "scripts/build-payload-2.js" {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+	script, err := ioutil.ReadFile(filepath.Join(dir, "scripts", "build-payload.js"))
+	if err != nil || string(script) != "done({a: 1});\n" {
+		t.Errorf("unexpected script file %q %v", script, err)
+	}
+
+	// edit a script on disk
+	err = ioutil.WriteFile(filepath.Join(dir, "scripts", "is-valid.js"), []byte("done(false);"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imploded, err := ImplodeFormula(dir)
+	if err != nil {
+		t.Fatalf("implode: %s", err)
+	}
+	d := DiffFormulas(f, imploded)
+	if len(d.Changes) != 1 || d.Changes[0].Subject != "is valid?" || d.Changes[0].New != "done(false);" {
+		t.Errorf("unexpected changes\n%s", d)
+	}
+	for i, s := range imploded.Steps {
+		if s.ID != f.Steps[i].ID || s.Name != f.Steps[i].Name {
+			t.Errorf("step %v changed from %+v to %+v", i, f.Steps[i], s)
+		}
+	}
+}
+
+func TestExplodeFormulaCaseInsensitiveNames(t *testing.T) {
+	used := make(map[string]bool)
+	first := scriptFileName("GetX", used)
+	second := scriptFileName("getx", used)
+	if first != "scripts/GetX.js" || second != "scripts/getx-2.js" {
+		t.Errorf("unexpected file names %s %s", first, second)
+	}
+}
+
+func TestImplodeFormulaUnsafeScriptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "implode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := Formula{Name: "unsafe", Steps: []Step{{Name: "run", Type: StepTypeScript, Properties: map[string]interface{}{"body": "done();"}}}}
+	_, err = ExplodeFormula(f, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(filepath.Dir(dir), "outside.js")
+	for _, file := range []string{"../outside.js", "scripts/../../outside.js", outside, "formula.json", "scripts/.hidden.js"} {
+		manifest := ExplodedManifest{Version: ExplodedFormulaVersion, Formula: f.Name, Scripts: []ExplodedScript{{Step: "run", File: file}}}
+		b, _ := json.Marshal(manifest)
+		err = ioutil.WriteFile(filepath.Join(dir, "manifest.json"), b, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ImplodeFormula(dir); err == nil {
+			t.Errorf("expected error for script file %q", file)
+		}
+	}
+}
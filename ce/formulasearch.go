@@ -0,0 +1,226 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FormulaSearch selects steps of Formulas by content; every criterion that
+// is set must match. ConfigurationKey and ElementKey also match triggers
+// when no step-only criterion (StepType, StepName, Script) is set.
+type FormulaSearch struct {
+	// StepType matches the step type, e.g. script or elementRequest
+	StepType string
+	// StepName matches step names
+	StepName *regexp.Regexp
+	// ConfigurationKey matches steps referencing ${config.<key>}
+	ConfigurationKey string
+	// ElementKey matches elementRequest steps calling an Element Instance of
+	// the Element, by configuration key or name, or by resolving the
+	// configured Element Instances of the Formula's Instances with Lookup
+	ElementKey string
+	// Script matches lines of script and filter step bodies
+	Script *regexp.Regexp
+	// Lookup resolves Element Instance IDs for ElementKey, may be nil
+	Lookup ElementInstanceLookup
+}
+
+// FormulaMatch is the location of a search match in a Formula; Step is empty
+// for a trigger match and Line is set for script matches
+type FormulaMatch struct {
+	FormulaID   int    `json:"formulaId"`
+	FormulaName string `json:"formulaName"`
+	Step        string `json:"step,omitempty"`
+	Type        string `json:"type"`
+	Trigger     bool   `json:"trigger,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Text        string `json:"text,omitempty"`
+}
+
+// String returns the location as formula:step[:line]
+func (m FormulaMatch) String() string {
+	loc := fmt.Sprintf("%v %s: ", m.FormulaID, m.FormulaName)
+	if m.Trigger {
+		loc += "trigger " + m.Type
+	} else {
+		loc += m.Step
+	}
+	if m.Line > 0 {
+		loc += fmt.Sprintf(":%v: %s", m.Line, strings.TrimSpace(m.Text))
+	}
+	return loc
+}
+
+// SearchFormulas returns the locations in formulas matching the search
+func SearchFormulas(formulas []Formula, search FormulaSearch) ([]FormulaMatch, error) {
+	var matches []FormulaMatch
+	elements := elementKeyResolver(search.Lookup)
+	stepOnly := search.StepType != "" || search.StepName != nil || search.Script != nil
+	for _, f := range formulas {
+		if !stepOnly && (search.ConfigurationKey != "" || search.ElementKey != "") {
+			for _, t := range f.Triggers {
+				ok, err := matchesReferences(f, t.Properties, search, elements)
+				if err != nil {
+					return matches, err
+				}
+				if ok {
+					matches = append(matches, FormulaMatch{FormulaID: f.ID, FormulaName: f.Name, Type: t.Type, Trigger: true})
+				}
+			}
+		}
+		for _, s := range f.Steps {
+			if search.StepType != "" && s.Type != search.StepType {
+				continue
+			}
+			if search.StepName != nil && !search.StepName.MatchString(s.Name) {
+				continue
+			}
+			if search.ElementKey != "" && s.Type != StepTypeElementRequest && s.Type != StepTypeElementRequestStream {
+				continue
+			}
+			ok, err := matchesReferences(f, s.Properties, search, elements)
+			if err != nil {
+				return matches, err
+			}
+			if !ok {
+				continue
+			}
+			match := FormulaMatch{FormulaID: f.ID, FormulaName: f.Name, Step: s.Name, Type: s.Type}
+			if search.Script == nil {
+				matches = append(matches, match)
+				continue
+			}
+			for _, l := range scriptLines(s, search.Script) {
+				match.Line = l.number
+				match.Text = l.text
+				matches = append(matches, match)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// SearchAccountFormulas searches every Formula in the account, resolving
+// Element Instances for ElementKey from the Platform when no Lookup is set
+func SearchAccountFormulas(base, auth string, search FormulaSearch) ([]FormulaMatch, error) {
+	formulabytes, status, _, err := FormulasList(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("Status code %v", status)
+	}
+	formulas, err := CombinedFormulaAndInstances(formulabytes, base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if search.ElementKey != "" && search.Lookup == nil {
+		search.Lookup = PlatformElementInstanceLookup(base, auth)
+	}
+	return SearchFormulas(formulas, search)
+}
+
+// matchesReferences checks the ConfigurationKey and ElementKey criteria
+// against step or trigger properties
+func matchesReferences(f Formula, properties interface{}, search FormulaSearch, elements func(string) (string, error)) (bool, error) {
+	if search.ConfigurationKey == "" && search.ElementKey == "" {
+		return true, nil
+	}
+	b, err := json.Marshal(properties)
+	if err != nil {
+		return false, err
+	}
+	if search.ConfigurationKey != "" && !configReferenced(string(b), search.ConfigurationKey) {
+		return false, nil
+	}
+	if search.ElementKey == "" {
+		return true, nil
+	}
+
+	props, _ := normalizeJSON(properties).(map[string]interface{})
+	target := fmt.Sprintf("%v", props["elementInstanceId"])
+	if _, err := strconv.Atoi(target); err == nil {
+		key, err := elements(target)
+		return strings.EqualFold(key, search.ElementKey), err
+	}
+	for _, k := range configReferences(target) {
+		if strings.EqualFold(k, search.ElementKey) {
+			return true, nil
+		}
+		for _, c := range f.Configuration {
+			if c.Key == k && strings.Contains(strings.ToLower(c.Name), strings.ToLower(search.ElementKey)) {
+				return true, nil
+			}
+		}
+		for _, i := range f.Instances {
+			id, ok := i.ConfigurationValues()[k]
+			if !ok {
+				continue
+			}
+			key, err := elements(id)
+			if err != nil {
+				return false, err
+			}
+			if strings.EqualFold(key, search.ElementKey) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// elementKeyResolver returns a caching resolver of Element Instance ID to
+// Element key; without a lookup every key is empty
+func elementKeyResolver(lookup ElementInstanceLookup) func(string) (string, error) {
+	cache := make(map[string]string)
+	return func(id string) (string, error) {
+		if lookup == nil {
+			return "", nil
+		}
+		if key, ok := cache[id]; ok {
+			return key, nil
+		}
+		instance, found, err := lookup(id)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			cache[id] = instance.Element.Key
+		} else {
+			cache[id] = ""
+		}
+		return cache[id], nil
+	}
+}
+
+type scriptLine struct {
+	number int
+	text   string
+}
+
+// scriptLines returns the lines of a script or filter step body matching re
+func scriptLines(s Step, re *regexp.Regexp) []scriptLine {
+	var body string
+	p, err := s.TypedProperties()
+	if err != nil {
+		return nil
+	}
+	switch t := p.(type) {
+	case *ScriptProperties:
+		body = t.Body
+	case *FilterProperties:
+		body = t.Body
+	default:
+		return nil
+	}
+	var lines []scriptLine
+	for i, l := range strings.Split(body, "\n") {
+		if re.MatchString(l) {
+			lines = append(lines, scriptLine{number: i + 1, text: l})
+		}
+	}
+	return lines
+}
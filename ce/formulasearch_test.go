@@ -0,0 +1,78 @@
+package ce
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const searchTestFormulas = `[
+	{"id": 1, "name": "sync contacts",
+		"configuration": [{"key": "crm", "name": "Salesforce instance", "type": "elementInstance"}, {"key": "target", "type": "elementInstance"}],
+		"triggers": [{"type": "event", "properties": {"elementInstanceId": "${config.crm}"}}],
+		"steps": [
+			{"name": "get contact", "type": "elementRequest", "properties": {"elementInstanceId": "${config.crm}", "api": "/hubs/crm/contacts"}},
+			{"name": "map contact", "type": "script", "properties": {"body": "var c = steps['get contact'].response.body;\ndone({email: c.Email, phone: c.Phone});"}},
+			{"name": "post contact", "type": "elementRequest", "properties": {"elementInstanceId": "${config['target']}", "api": "/hubs/marketing/contacts"}}
+		],
+		"instances": [{"id": 10, "configuration": {"crm": "500", "target": "600"}}]
+	},
+	{"id": 2, "name": "nightly report",
+		"configuration": [{"key": "sfdc", "type": "elementInstance"}],
+		"triggers": [{"type": "scheduled", "properties": {"cron": "0 0 * * *"}}],
+		"steps": [
+			{"name": "query", "type": "elementRequest", "properties": {"elementInstanceId": "${config.sfdc}", "api": "/hubs/crm/accounts"}},
+			{"name": "is big?", "type": "filter", "properties": {"body": "done(steps.query.response.body.length > 100);"}},
+			{"name": "fixed", "type": "elementRequest", "properties": {"elementInstanceId": "700", "api": "/hubs/crm/leads"}},
+			{"name": "backup", "type": "script", "properties": {"body": "done({instance: config.sfdcBackup});"}}
+		]
+	}
+]`
+
+func TestSearchFormulas(t *testing.T) {
+	var formulas []Formula
+	if err := json.Unmarshal([]byte(searchTestFormulas), &formulas); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(id string) (ElementInstance, bool, error) {
+		keys := map[string]string{"500": "sfdc", "600": "hubspot", "700": "sfdc"}
+		key, ok := keys[id]
+		return ElementInstance{Element: Element{Key: key}}, ok, nil
+	}
+	locations := func(matches []FormulaMatch) string {
+		var l []string
+		for _, m := range matches {
+			l = append(l, m.String())
+		}
+		return strings.Join(l, "; ")
+	}
+
+	cases := []struct {
+		name   string
+		search FormulaSearch
+		want   string
+	}{
+		{"step type", FormulaSearch{StepType: StepTypeFilter}, "2 nightly report: is big?"},
+		{"step name", FormulaSearch{StepName: regexp.MustCompile(`contact$`)}, "1 sync contacts: get contact; 1 sync contacts: map contact; 1 sync contacts: post contact"},
+		{"configuration key", FormulaSearch{ConfigurationKey: "target"}, "1 sync contacts: post contact"},
+		{"configuration key with trigger", FormulaSearch{ConfigurationKey: "crm"}, "1 sync contacts: trigger event; 1 sync contacts: get contact"},
+		{"configuration key prefix", FormulaSearch{ConfigurationKey: "sfdc"}, "2 nightly report: query"},
+		{"element key", FormulaSearch{ElementKey: "sfdc", Lookup: lookup}, "1 sync contacts: trigger event; 1 sync contacts: get contact; 2 nightly report: query; 2 nightly report: fixed"},
+		{"element key by name", FormulaSearch{ElementKey: "salesforce"}, "1 sync contacts: trigger event; 1 sync contacts: get contact"},
+		{"element key in steps", FormulaSearch{ElementKey: "hubspot", StepType: StepTypeElementRequest, Lookup: lookup}, "1 sync contacts: post contact"},
+		{"script", FormulaSearch{Script: regexp.MustCompile(`\.Email\b`)}, "1 sync contacts: map contact:2: done({email: c.Email, phone: c.Phone});"},
+		{"script in filter", FormulaSearch{Script: regexp.MustCompile(`steps\.query`), StepType: StepTypeFilter}, "2 nightly report: is big?:1: done(steps.query.response.body.length > 100);"},
+		{"no match", FormulaSearch{StepType: StepTypeLoop}, ""},
+	}
+	for _, c := range cases {
+		matches, err := SearchFormulas(formulas, c.search)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if got := locations(matches); got != c.want {
+			t.Errorf("%s:\nwant %s\ngot  %s", c.name, c.want, got)
+		}
+	}
+}
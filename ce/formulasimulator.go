@@ -0,0 +1,415 @@
+package ce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// DefaultSimulationMaxSteps bounds the number of steps a simulation executes
+const DefaultSimulationMaxSteps = 1000
+
+// DefaultSimulationScriptTimeout bounds the time a single script or reference
+// expression may run
+const DefaultSimulationScriptTimeout = 5 * time.Second
+
+// SimulatedResponse is a canned response for an elementRequest or httpRequest step
+type SimulatedResponse struct {
+	Code    int               `json:"code"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+// StepStub returns the response for a stubbed step; properties are the step's
+// properties with ${} references resolved against the execution context
+type StepStub func(step Step, properties map[string]interface{}) (SimulatedResponse, error)
+
+// StubResponse returns a StepStub that always responds with code and body
+func StubResponse(code int, body interface{}) StepStub {
+	return func(Step, map[string]interface{}) (SimulatedResponse, error) {
+		return SimulatedResponse{Code: code, Body: body}, nil
+	}
+}
+
+// SimulatedStep is an entry in the trace of a simulation
+type SimulatedStep struct {
+	Step   string      `json:"step"`
+	Type   string      `json:"type"`
+	Status string      `json:"status"`
+	Output interface{} `json:"output,omitempty"`
+	Logs   []string    `json:"logs,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Simulation is the result of running a Formula in a Simulator
+type Simulation struct {
+	Status string                 `json:"status"`
+	Trace  []SimulatedStep        `json:"trace"`
+	Steps  map[string]interface{} `json:"steps"`
+}
+
+// Simulator executes a Formula locally, from its trigger through the
+// onSuccess and onFailure edges, without calling the platform. Script and
+// filter steps run in an embedded JavaScript engine with trigger, steps,
+// config and info in scope and must call done(), and are halted after
+// ScriptTimeout; loop steps iterate their list; every other step type must be
+// stubbed.
+type Simulator struct {
+	Formula       Formula
+	Configuration map[string]string
+	Info          map[string]interface{}
+	Stubs         map[string]StepStub
+	MaxSteps      int
+	ScriptTimeout time.Duration
+}
+
+// NewSimulator returns a Simulator for a Formula
+func NewSimulator(f Formula) *Simulator {
+	return &Simulator{
+		Formula:       f,
+		Configuration: make(map[string]string),
+		Info:          map[string]interface{}{"formulaId": f.ID},
+		Stubs:         make(map[string]StepStub),
+		MaxSteps:      DefaultSimulationMaxSteps,
+		ScriptTimeout: DefaultSimulationScriptTimeout,
+	}
+}
+
+// Stub sets the StepStub for a named step
+func (s *Simulator) Stub(step string, stub StepStub) *Simulator {
+	s.Stubs[step] = stub
+	return s
+}
+
+// Run simulates an execution of the Formula started by the given trigger
+// payload, e.g. {"event": {...}} for an event trigger. The returned error
+// reports a problem with the simulation itself; step failures are recorded
+// in the trace and follow the step's onFailure edge.
+func (s *Simulator) Run(trigger interface{}) (Simulation, error) {
+	sim := Simulation{Steps: make(map[string]interface{})}
+	if len(s.Formula.Triggers) == 0 {
+		return sim, fmt.Errorf("formula %s has no trigger", s.Formula.Name)
+	}
+	steps := make(map[string]Step)
+	for _, st := range s.Formula.Steps {
+		steps[st.Name] = st
+	}
+	max := s.MaxSteps
+	if max <= 0 {
+		max = DefaultSimulationMaxSteps
+	}
+
+	ctx := map[string]interface{}{
+		"trigger": normalizeJSON(trigger),
+		"steps":   sim.Steps,
+		"config":  s.Configuration,
+		"info":    s.Info,
+	}
+	queue := append([]string{}, s.Formula.Triggers[0].OnSuccess...)
+	loops := make(map[string]int)
+	sim.Status = ExecutionSuccess
+	for len(queue) > 0 {
+		if len(sim.Trace) >= max {
+			return sim, fmt.Errorf("simulation exceeded %v steps", max)
+		}
+		name := queue[0]
+		queue = queue[1:]
+		st, ok := steps[name]
+		if !ok {
+			return sim, fmt.Errorf("unknown step %s", name)
+		}
+
+		entry, err := s.runStep(st, ctx, loops)
+		if err != nil {
+			return sim, err
+		}
+		sim.Trace = append(sim.Trace, entry)
+		if entry.Output != nil {
+			sim.Steps[st.Name] = entry.Output
+		}
+		if entry.Status == ExecutionSuccess {
+			queue = append(queue, st.OnSuccess...)
+			continue
+		}
+		if len(st.OnFailure) == 0 && entry.Error != "" {
+			sim.Status = ExecutionFailed
+		}
+		queue = append(queue, st.OnFailure...)
+	}
+	return sim, nil
+}
+
+// runStep executes a single step; filters returning false and exhausted
+// loops are recorded as failed so that their onFailure edge is followed
+func (s *Simulator) runStep(st Step, ctx map[string]interface{}, loops map[string]int) (SimulatedStep, error) {
+	entry := SimulatedStep{Step: st.Name, Type: st.Type, Status: ExecutionSuccess}
+	props, _ := normalizeJSON(st.Properties).(map[string]interface{})
+	timeout := s.ScriptTimeout
+	if timeout <= 0 {
+		timeout = DefaultSimulationScriptTimeout
+	}
+
+	switch st.Type {
+	case StepTypeScript, StepTypeFilter:
+		body, _ := props["body"].(string)
+		out, logs, err := runScript(body, ctx, timeout)
+		entry.Logs = logs
+		if err != nil {
+			entry.Status = ExecutionFailed
+			entry.Error = err.Error()
+			return entry, nil
+		}
+		if st.Type == StepTypeFilter {
+			pass, ok := out.(bool)
+			if !ok {
+				entry.Status = ExecutionFailed
+				entry.Error = fmt.Sprintf("filter must call done() with a boolean, got %v", out)
+				return entry, nil
+			}
+			entry.Output = map[string]interface{}{"continue": pass}
+			if !pass {
+				entry.Status = ExecutionFailed
+			}
+			return entry, nil
+		}
+		entry.Output = out
+		return entry, nil
+
+	case StepTypeLoop:
+		list, _ := props["list"].(string)
+		v, err := resolveReferences(list, ctx, timeout)
+		if err != nil {
+			return entry, fmt.Errorf("step %s: %s", st.Name, err)
+		}
+		entries, _ := v.([]interface{})
+		i := loops[st.Name]
+		if i >= len(entries) {
+			delete(loops, st.Name)
+			entry.Status = ExecutionFailed
+			return entry, nil
+		}
+		loops[st.Name] = i + 1
+		entry.Output = map[string]interface{}{"index": i, "entry": entries[i]}
+		return entry, nil
+	}
+
+	stub, ok := s.Stubs[st.Name]
+	if !ok {
+		return entry, fmt.Errorf("step %s of type %s is not stubbed", st.Name, st.Type)
+	}
+	resolved, err := resolveReferences(props, ctx, timeout)
+	if err != nil {
+		return entry, fmt.Errorf("step %s: %s", st.Name, err)
+	}
+	rp, _ := resolved.(map[string]interface{})
+	resp, err := stub(st, rp)
+	if err != nil {
+		entry.Status = ExecutionFailed
+		entry.Error = err.Error()
+		return entry, nil
+	}
+	entry.Output = map[string]interface{}{
+		"request":  rp,
+		"response": normalizeJSON(resp),
+	}
+	if !acceptableStatus(resp.Code, rp["acceptableStatusCodes"]) {
+		entry.Status = ExecutionFailed
+		entry.Error = fmt.Sprintf("unacceptable status code %v", resp.Code)
+	}
+	return entry, nil
+}
+
+// scriptPrelude defines the platform's done() and console.log conventions
+const scriptPrelude = `
+var __result, __done = false, __logs = [];
+var done = function(v) { __done = true; __result = JSON.stringify(v === undefined ? null : v); };
+var console = {log: function() {
+	var parts = [];
+	for (var i = 0; i < arguments.length; i++) {
+		parts.push(typeof arguments[i] === 'string' ? arguments[i] : JSON.stringify(arguments[i]));
+	}
+	__logs.push(parts.join(' '));
+}};
+`
+
+// newScriptVM returns a JavaScript VM with the execution context in scope
+func newScriptVM(ctx map[string]interface{}) (*otto.Otto, error) {
+	vm := otto.New()
+	_, err := vm.Run(scriptPrelude)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range ctx {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		_, err = vm.Run(fmt.Sprintf("var %s = %s;", k, b))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vm, nil
+}
+
+// errScriptHalted is the panic used to halt a script past its deadline
+var errScriptHalted = errors.New("script halted")
+
+// runWithTimeout runs src in vm, halting it if it runs longer than timeout
+func runWithTimeout(vm *otto.Otto, src string, timeout time.Duration) (v otto.Value, err error) {
+	interrupt := make(chan func(), 1)
+	vm.Interrupt = interrupt
+	timer := time.AfterFunc(timeout, func() {
+		interrupt <- func() { panic(errScriptHalted) }
+	})
+	defer func() {
+		timer.Stop()
+		vm.Interrupt = nil
+		if r := recover(); r != nil {
+			if r != errScriptHalted {
+				panic(r)
+			}
+			err = fmt.Errorf("script did not finish within %s", timeout)
+		}
+	}()
+	return vm.Run(src)
+}
+
+// runScript runs a script body, returning the value passed to done() and
+// anything logged to the console
+func runScript(body string, ctx map[string]interface{}, timeout time.Duration) (interface{}, []string, error) {
+	vm, err := newScriptVM(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// scripts may return early, so they run as a function body
+	_, runErr := runWithTimeout(vm, "(function() {\n"+body+"\n})();", timeout)
+
+	var logs []string
+	l, _ := vm.Run("JSON.stringify(__logs)")
+	json.Unmarshal([]byte(l.String()), &logs)
+	if runErr != nil {
+		return nil, logs, runErr
+	}
+
+	called, _ := vm.Get("__done")
+	if ok, _ := called.ToBoolean(); !ok {
+		return nil, logs, fmt.Errorf("script did not call done()")
+	}
+	result, _ := vm.Get("__result")
+	var out interface{}
+	err = json.Unmarshal([]byte(result.String()), &out)
+	return out, logs, err
+}
+
+var referencePattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// resolveReferences replaces ${expression} references in a JSON value; a
+// string that is a single reference takes the referenced value as is
+func resolveReferences(v interface{}, ctx map[string]interface{}, timeout time.Duration) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		if !strings.Contains(t, "${") {
+			return t, nil
+		}
+		vm, err := newScriptVM(ctx)
+		if err != nil {
+			return nil, err
+		}
+		eval := func(expr string) (interface{}, error) {
+			r, err := runWithTimeout(vm, "JSON.stringify("+expr+")", timeout)
+			if err != nil {
+				return nil, fmt.Errorf("${%s}: %s", expr, err)
+			}
+			var out interface{}
+			if r.IsUndefined() {
+				return nil, nil
+			}
+			err = json.Unmarshal([]byte(r.String()), &out)
+			return out, err
+		}
+		if m := referencePattern.FindStringSubmatch(t); m != nil && m[0] == t {
+			return eval(m[1])
+		}
+		var evalErr error
+		s := referencePattern.ReplaceAllStringFunc(t, func(ref string) string {
+			out, err := eval(ref[2 : len(ref)-1])
+			if err != nil {
+				evalErr = err
+			}
+			if out == nil {
+				return ""
+			}
+			if str, ok := out.(string); ok {
+				return str
+			}
+			return fmt.Sprintf("%v", out)
+		})
+		return s, evalErr
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			r, err := resolveReferences(val, ctx, timeout)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = r
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			r, err := resolveReferences(val, ctx, timeout)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = r
+		}
+		return l, nil
+	}
+	return v, nil
+}
+
+// acceptableStatus reports whether code is acceptable; acceptable codes may
+// be a list or a comma separated string of codes and ranges such as 200-299,
+// defaulting to any 2xx
+func acceptableStatus(code int, acceptable interface{}) bool {
+	var specs []string
+	switch t := acceptable.(type) {
+	case string:
+		specs = strings.Split(t, ",")
+	case []interface{}:
+		for _, v := range t {
+			specs = append(specs, fmt.Sprintf("%v", v))
+		}
+	case float64:
+		specs = []string{strconv.Itoa(int(t))}
+	}
+	if len(specs) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, spec := range specs {
+		bounds := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+		lo, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			continue
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			hi, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				continue
+			}
+		}
+		if code >= lo && code <= hi {
+			return true
+		}
+	}
+	return false
+}
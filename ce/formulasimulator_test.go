@@ -0,0 +1,146 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const simulatorTestFormula = `{
+	"name": "sync contacts",
+	"triggers": [{"type": "event", "onSuccess": ["is create?"]}],
+	"steps": [
+		{"name": "is create?", "type": "filter", "onSuccess": ["get contact"], "onFailure": ["ignored"],
+			"properties": {"body": "done(trigger.event.eventType === 'CREATED');"}},
+		{"name": "ignored", "type": "script", "properties": {"body": "done({ignored: true});"}},
+		{"name": "get contact", "type": "elementRequest", "onSuccess": ["loop emails"], "onFailure": ["log failure"],
+			"properties": {"elementInstanceId": "${config.source}", "method": "GET", "api": "/hubs/crm/contacts/${trigger.event.objectId}"}},
+		{"name": "loop emails", "type": "loop", "onSuccess": ["build"], "properties": {"list": "${steps['get contact'].response.body.emails}"}},
+		{"name": "build", "type": "script", "onSuccess": ["post"],
+			"properties": {"body": "console.log('email', steps['loop emails'].index);\ndone({email: steps['loop emails'].entry.toUpperCase()});"}},
+		{"name": "post", "type": "httpRequest", "onSuccess": ["loop emails"],
+			"properties": {"url": "https://example.com/${steps.build.email}", "method": "POST", "body": "${steps.build}"}},
+		{"name": "log failure", "type": "script", "properties": {"body": "throw new Error('lookup failed: ' + steps['get contact'].response.code);"}}
+	]
+}`
+
+func TestSimulator(t *testing.T) {
+	var f Formula
+	if err := json.Unmarshal([]byte(simulatorTestFormula), &f); err != nil {
+		t.Fatal(err)
+	}
+
+	var posted []interface{}
+	sim := NewSimulator(f)
+	sim.Configuration["source"] = "123"
+	sim.Stub("get contact", func(step Step, p map[string]interface{}) (SimulatedResponse, error) {
+		if p["elementInstanceId"] != "123" || p["api"] != "/hubs/crm/contacts/c1" {
+			return SimulatedResponse{}, fmt.Errorf("unexpected request %v", p)
+		}
+		return SimulatedResponse{Code: 200, Body: map[string]interface{}{"emails": []string{"a@x", "b@x"}}}, nil
+	})
+	sim.Stub("post", func(step Step, p map[string]interface{}) (SimulatedResponse, error) {
+		posted = append(posted, p["url"], p["body"])
+		return SimulatedResponse{Code: 201}, nil
+	})
+
+	run, err := sim.Run(map[string]interface{}{"event": map[string]interface{}{"eventType": "CREATED", "objectId": "c1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, s := range run.Trace {
+		order = append(order, s.Step+":"+s.Status)
+	}
+	want := []string{"is create?:success", "get contact:success",
+		"loop emails:success", "build:success", "post:success",
+		"loop emails:success", "build:success", "post:success",
+		"loop emails:failed"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("unexpected trace\nwant %v\ngot  %v", want, order)
+	}
+	if run.Status != ExecutionSuccess {
+		t.Errorf("expected success, got %s", run.Status)
+	}
+	wantPosted := []interface{}{
+		"https://example.com/A@X", map[string]interface{}{"email": "A@X"},
+		"https://example.com/B@X", map[string]interface{}{"email": "B@X"},
+	}
+	if !reflect.DeepEqual(posted, wantPosted) {
+		t.Errorf("unexpected requests %v", posted)
+	}
+	if logs := run.Trace[6].Logs; len(logs) != 1 || logs[0] != "email 1" {
+		t.Errorf("unexpected logs %v", logs)
+	}
+
+	// filter false follows onFailure
+	run, err = sim.Run(map[string]interface{}{"event": map[string]interface{}{"eventType": "UPDATED"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Trace) != 2 || run.Trace[1].Step != "ignored" || !reflect.DeepEqual(run.Steps["ignored"], map[string]interface{}{"ignored": true}) {
+		t.Errorf("unexpected trace %+v", run.Trace)
+	}
+
+	// unacceptable response follows onFailure, and the throwing script fails the execution
+	sim.Stub("get contact", StubResponse(404, nil))
+	run, err = sim.Run(map[string]interface{}{"event": map[string]interface{}{"eventType": "CREATED", "objectId": "c1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := run.Trace[len(run.Trace)-1]
+	if last.Step != "log failure" || last.Error == "" || run.Status != ExecutionFailed {
+		t.Errorf("unexpected result %s %+v", run.Status, last)
+	}
+
+	// unstubbed steps are a simulation error
+	delete(sim.Stubs, "get contact")
+	if _, err = sim.Run(map[string]interface{}{"event": map[string]interface{}{"eventType": "CREATED"}}); err == nil {
+		t.Errorf("expected error for unstubbed step")
+	}
+}
+
+func TestSimulatorScriptTimeout(t *testing.T) {
+	f := Formula{
+		Name:     "spin",
+		Triggers: []Trigger{{Type: "manual", OnSuccess: []string{"spin"}}},
+		Steps: []Step{
+			{Name: "spin", Type: StepTypeScript, OnFailure: []string{"recover"}, Properties: map[string]interface{}{"body": "while (true) {}"}},
+			{Name: "recover", Type: StepTypeScript, Properties: map[string]interface{}{"body": "done({recovered: true});"}},
+		},
+	}
+	sim := NewSimulator(f)
+	sim.ScriptTimeout = 50 * time.Millisecond
+	run, err := sim.Run(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Trace) != 2 || run.Trace[0].Status != ExecutionFailed || !strings.Contains(run.Trace[0].Error, "did not finish") {
+		t.Fatalf("unexpected trace %+v", run.Trace)
+	}
+	if run.Trace[1].Status != ExecutionSuccess || run.Status != ExecutionSuccess {
+		t.Errorf("expected the failure edge to run, got %+v", run)
+	}
+}
+
+func TestAcceptableStatus(t *testing.T) {
+	cases := []struct {
+		code       int
+		acceptable interface{}
+		want       bool
+	}{
+		{200, nil, true},
+		{404, nil, false},
+		{404, "200,404", true},
+		{302, "200-299, 300-304", true},
+		{500, []interface{}{200.0, 201.0}, false},
+	}
+	for _, c := range cases {
+		if got := acceptableStatus(c.code, c.acceptable); got != c.want {
+			t.Errorf("acceptableStatus(%v, %v) = %v", c.code, c.acceptable, got)
+		}
+	}
+}
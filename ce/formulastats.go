@@ -0,0 +1,262 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// ExecutionStats summarizes the executions of a Formula, a Formula Instance or
+// all Formulas
+type ExecutionStats struct {
+	FormulaID           int           `json:"formulaId,omitempty"`
+	FormulaName         string        `json:"formulaName,omitempty"`
+	FormulaInstanceID   int           `json:"formulaInstanceId,omitempty"`
+	FormulaInstanceName string        `json:"formulaInstanceName,omitempty"`
+	Executions          int           `json:"executions"`
+	Success             int           `json:"success"`
+	Failed              int           `json:"failed"`
+	Cancelled           int           `json:"cancelled"`
+	Other               int           `json:"other"`
+	SuccessRatio        float64       `json:"successRatio"`
+	FailureRatio        float64       `json:"failureRatio"`
+	CancelledRatio      float64       `json:"cancelledRatio"`
+	MedianDuration      time.Duration `json:"-"`
+	P95Duration         time.Duration `json:"-"`
+	MedianDurationMs    int64         `json:"medianDurationMs"`
+	P95DurationMs       int64         `json:"p95DurationMs"`
+	TopFailingStep      string        `json:"topFailingStep,omitempty"`
+	TopFailingStepCount int           `json:"topFailingStepCount,omitempty"`
+}
+
+// ExecutionStatsReport holds execution statistics per Formula Instance, per
+// Formula and in total
+type ExecutionStatsReport struct {
+	Instances []ExecutionStats `json:"instances"`
+	Formulas  []ExecutionStats `json:"formulas"`
+	Total     ExecutionStats   `json:"total"`
+}
+
+// ExecutionStatsOptions selects the executions in an ExecutionStatsReport;
+// with FailingSteps the steps of each failed execution are retrieved to find
+// the step that failed
+type ExecutionStatsOptions struct {
+	Filter       ExecutionFilter
+	FailingSteps bool
+	Debug        bool
+}
+
+// ComputeExecutionStats returns the statistics of a set of executions;
+// failedSteps maps an execution ID to the name of the step it failed on
+func ComputeExecutionStats(executions []FormulaInstanceExecution, failedSteps map[int]string) ExecutionStats {
+	var stats ExecutionStats
+	var durations []time.Duration
+	failing := make(map[string]int)
+	for _, e := range executions {
+		stats.Executions++
+		switch e.Status {
+		case ExecutionSuccess:
+			stats.Success++
+		case ExecutionFailed:
+			stats.Failed++
+			if step, ok := failedSteps[e.ID]; ok && step != "" {
+				failing[step]++
+			}
+		case ExecutionCancelled:
+			stats.Cancelled++
+		default:
+			stats.Other++
+		}
+		if ExecutionFinished(e.Status) && !e.CreateDate.IsZero() && !e.UpdatedDate.IsZero() {
+			durations = append(durations, e.UpdatedDate.Sub(e.CreateDate))
+		}
+	}
+	if stats.Executions > 0 {
+		n := float64(stats.Executions)
+		stats.SuccessRatio = float64(stats.Success) / n
+		stats.FailureRatio = float64(stats.Failed) / n
+		stats.CancelledRatio = float64(stats.Cancelled) / n
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	stats.MedianDuration = percentileDuration(durations, 50)
+	stats.P95Duration = percentileDuration(durations, 95)
+	stats.MedianDurationMs = int64(stats.MedianDuration / time.Millisecond)
+	stats.P95DurationMs = int64(stats.P95Duration / time.Millisecond)
+	for step, count := range failing {
+		if count > stats.TopFailingStepCount || (count == stats.TopFailingStepCount && step < stats.TopFailingStep) {
+			stats.TopFailingStep = step
+			stats.TopFailingStepCount = count
+		}
+	}
+	return stats
+}
+
+// percentileDuration returns the nearest-rank percentile of sorted durations
+func percentileDuration(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// FailedStep returns the name of the last failed step of an execution
+func FailedStep(steps []StepExecution) string {
+	sorted := make([]StepExecution, len(steps))
+	copy(sorted, steps)
+	sortStepExecutions(sorted)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if sorted[i].Status == ExecutionFailed {
+			return sorted[i].StepName
+		}
+	}
+	return ""
+}
+
+// BuildExecutionStatsReport computes an ExecutionStatsReport from Formulas,
+// with their Instances, and the executions of each Formula Instance
+func BuildExecutionStatsReport(formulas []Formula, executions map[int][]FormulaInstanceExecution, failedSteps map[int]string) ExecutionStatsReport {
+	report := ExecutionStatsReport{Instances: []ExecutionStats{}, Formulas: []ExecutionStats{}}
+	var all []FormulaInstanceExecution
+	for _, f := range formulas {
+		var formulaExecutions []FormulaInstanceExecution
+		for _, i := range f.Instances {
+			stats := ComputeExecutionStats(executions[i.ID], failedSteps)
+			stats.FormulaID = f.ID
+			stats.FormulaName = f.Name
+			stats.FormulaInstanceID = i.ID
+			stats.FormulaInstanceName = i.Name
+			report.Instances = append(report.Instances, stats)
+			formulaExecutions = append(formulaExecutions, executions[i.ID]...)
+		}
+		stats := ComputeExecutionStats(formulaExecutions, failedSteps)
+		stats.FormulaID = f.ID
+		stats.FormulaName = f.Name
+		report.Formulas = append(report.Formulas, stats)
+		all = append(all, formulaExecutions...)
+	}
+	report.Total = ComputeExecutionStats(all, failedSteps)
+	return report
+}
+
+// GetExecutionStatsReport retrieves the executions of every Formula Instance
+// in the account and computes their statistics
+func GetExecutionStatsReport(base, auth string, opts ExecutionStatsOptions) (ExecutionStatsReport, error) {
+	formulabytes, status, _, err := FormulasList(base, auth)
+	if err != nil {
+		return ExecutionStatsReport{}, err
+	}
+	if status != 200 {
+		return ExecutionStatsReport{}, fmt.Errorf("Status code %v", status)
+	}
+	formulas, err := CombinedFormulaAndInstances(formulabytes, base, auth)
+	if err != nil {
+		return ExecutionStatsReport{}, err
+	}
+
+	executions := make(map[int][]FormulaInstanceExecution)
+	failedSteps := make(map[int]string)
+	for _, f := range formulas {
+		for _, i := range f.Instances {
+			if opts.Debug {
+				log.Printf("Retrieving executions of formula instance %v", i.ID)
+			}
+			filter := opts.Filter
+			filter.FormulaInstanceIDs = []string{strconv.Itoa(i.ID)}
+			list, err := FindFormulaExecutions(base, auth, filter)
+			if err != nil {
+				return ExecutionStatsReport{}, err
+			}
+			for _, e := range list {
+				executions[i.ID] = append(executions[i.ID], e)
+				if opts.FailingSteps && e.Status == ExecutionFailed {
+					steps, err := GetFormulaExecutionSteps(base, auth, strconv.Itoa(e.ID))
+					if err != nil {
+						return ExecutionStatsReport{}, fmt.Errorf("execution %v: %s", e.ID, err)
+					}
+					failedSteps[e.ID] = FailedStep(steps)
+				}
+			}
+		}
+	}
+	return BuildExecutionStatsReport(formulas, executions, failedSteps), nil
+}
+
+// WriteExecutionStatsReport renders an ExecutionStatsReport as a table or json
+func WriteExecutionStatsReport(w io.Writer, report ExecutionStatsReport, format string) error {
+	switch format {
+	case ReportFormatTable:
+		return writeExecutionStatsTable(w, report)
+	case ReportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return fmt.Errorf("unknown report format %s", format)
+}
+
+// ExecutionStatsReportOutput prints an ExecutionStatsReport table to stdout
+func ExecutionStatsReportOutput(report ExecutionStatsReport) error {
+	return WriteExecutionStatsReport(os.Stdout, report, ReportFormatTable)
+}
+
+func executionStatsRecord(subject string, s ExecutionStats) []string {
+	percent := func(r float64) string {
+		return strconv.FormatFloat(r*100, 'f', 1, 64) + "%"
+	}
+	failing := ""
+	if s.TopFailingStep != "" {
+		failing = fmt.Sprintf("%s (%v)", s.TopFailingStep, s.TopFailingStepCount)
+	}
+	return []string{
+		subject,
+		strconv.Itoa(s.Executions),
+		percent(s.SuccessRatio),
+		percent(s.FailureRatio),
+		percent(s.CancelledRatio),
+		s.MedianDuration.String(),
+		s.P95Duration.String(),
+		failing,
+	}
+}
+
+func writeExecutionStatsTable(w io.Writer, report ExecutionStatsReport) error {
+	header := []string{"", "Executions", "Success", "Failed", "Cancelled", "Median", "P95", "Top failing step"}
+	render := func(title, subject string, data [][]string) {
+		fmt.Fprintf(w, "%s\n\n", title)
+		header[0] = subject
+		table := tablewriter.NewWriter(w)
+		table.SetHeader(header)
+		table.SetBorder(false)
+		table.AppendBulk(data)
+		table.Render()
+		fmt.Fprintln(w)
+	}
+
+	data := [][]string{}
+	for _, s := range report.Formulas {
+		data = append(data, executionStatsRecord(fmt.Sprintf("%v %s", s.FormulaID, s.FormulaName), s))
+	}
+	render("Formulas", "Formula", data)
+
+	data = [][]string{}
+	for _, s := range report.Instances {
+		data = append(data, executionStatsRecord(fmt.Sprintf("%v %s (formula %v)", s.FormulaInstanceID, s.FormulaInstanceName, s.FormulaID), s))
+	}
+	render("Instances", "Instance", data)
+
+	data = [][]string{executionStatsRecord("All", report.Total)}
+	render("Totals", "", data)
+	return nil
+}
@@ -0,0 +1,108 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeExecutionStats(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var executions []FormulaInstanceExecution
+	statuses := []string{ExecutionSuccess, ExecutionSuccess, ExecutionFailed, ExecutionSuccess, ExecutionFailed,
+		ExecutionCancelled, ExecutionSuccess, ExecutionFailed, ExecutionSuccess, ExecutionPending}
+	for i, s := range statuses {
+		executions = append(executions, FormulaInstanceExecution{
+			ID:          i + 1,
+			Status:      s,
+			CreateDate:  start,
+			UpdatedDate: start.Add(time.Duration(i+1) * time.Second),
+		})
+	}
+	failed := map[int]string{3: "get contact", 5: "post", 8: "get contact"}
+
+	s := ComputeExecutionStats(executions, failed)
+	if s.Executions != 10 || s.Success != 5 || s.Failed != 3 || s.Cancelled != 1 || s.Other != 1 {
+		t.Errorf("unexpected counts %+v", s)
+	}
+	if s.SuccessRatio != 0.5 || s.FailureRatio != 0.3 || s.CancelledRatio != 0.1 {
+		t.Errorf("unexpected ratios %+v", s)
+	}
+	// durations of the 9 finished executions are 1s to 9s
+	if s.MedianDuration != 5*time.Second || s.P95Duration != 9*time.Second {
+		t.Errorf("unexpected durations median %s p95 %s", s.MedianDuration, s.P95Duration)
+	}
+	if s.TopFailingStep != "get contact" || s.TopFailingStepCount != 2 {
+		t.Errorf("unexpected top failing step %s (%v)", s.TopFailingStep, s.TopFailingStepCount)
+	}
+
+	empty := ComputeExecutionStats(nil, nil)
+	if empty.Executions != 0 || empty.SuccessRatio != 0 || empty.MedianDuration != 0 {
+		t.Errorf("unexpected empty stats %+v", empty)
+	}
+}
+
+func TestGetExecutionStatsReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/formulas":
+			w.Write([]byte(`[{"id": 1, "name": "sync", "triggers": [{"type": "event"}]}]`))
+		case "/formulas/1/instances":
+			w.Write([]byte(`[{"id": 10, "name": "sync a"}, {"id": 11, "name": "sync b"}]`))
+		case "/formulas/instances/10/executions":
+			if r.URL.Query().Get("nextPage") == "" {
+				w.Header().Set(NextPageTokenHeader, "2")
+				w.Write([]byte(`[{"id": 100, "status": "success", "createdDate": "2018-01-01T00:00:00Z", "updatedDate": "2018-01-01T00:00:02Z"}]`))
+				return
+			}
+			w.Write([]byte(`[{"id": 101, "status": "failed", "createdDate": "2018-01-01T00:00:00Z", "updatedDate": "2018-01-01T00:00:04Z"}]`))
+		case "/formulas/instances/11/executions":
+			w.Write([]byte(`[{"id": 110, "status": "failed", "createdDate": "2018-01-01T00:00:00Z", "updatedDate": "2018-01-01T00:00:06Z"}]`))
+		case "/formulas/instances/executions/101/steps", "/formulas/instances/executions/110/steps":
+			w.Write([]byte(`[
+				{"id": 1, "stepName": "transform", "status": "success", "createdDate": "2018-01-01T00:00:01Z"},
+				{"id": 2, "stepName": "post", "status": "failed", "createdDate": "2018-01-01T00:00:02Z"}
+			]`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	report, err := GetExecutionStatsReport(ts.URL, auth, ExecutionStatsOptions{FailingSteps: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Instances) != 2 || report.Instances[1].FormulaInstanceName != "sync b" || report.Instances[1].Failed != 1 {
+		t.Errorf("unexpected instances %+v", report.Instances)
+	}
+	if len(report.Formulas) != 1 || report.Formulas[0].Executions != 3 || report.Formulas[0].TopFailingStep != "post" {
+		t.Errorf("unexpected formulas %+v", report.Formulas)
+	}
+	if report.Total.MedianDuration != 4*time.Second {
+		t.Errorf("unexpected total %+v", report.Total)
+	}
+
+	var b bytes.Buffer
+	if err = WriteExecutionStatsReport(&b, report, ReportFormatTable); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "1 sync") || !strings.Contains(b.String(), "post (2)") {
+		t.Errorf("unexpected table\n%s", b.String())
+	}
+	b.Reset()
+	if err = WriteExecutionStatsReport(&b, report, ReportFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ExecutionStatsReport
+	if err = json.Unmarshal(b.Bytes(), &decoded); err != nil || decoded.Total.Executions != 3 || decoded.Total.MedianDurationMs != 4000 {
+		t.Errorf("unexpected json %s %v", b.String(), err)
+	}
+	if WriteExecutionStatsReport(&b, report, "xml") == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
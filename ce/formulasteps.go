@@ -0,0 +1,424 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Step types
+const (
+	StepTypeScript                = "script"
+	StepTypeFilter                = "filter"
+	StepTypeElementRequest        = "elementRequest"
+	StepTypeElementRequestStream  = "elementRequestStream"
+	StepTypeHTTPRequest           = "httpRequest"
+	StepTypeLoop                  = "loop"
+	StepTypeFormula               = "formula"
+	StepTypeNotification          = "notification"
+	StepTypeRetryFormulaExecution = "retryFormulaExecution"
+)
+
+// Trigger types
+const (
+	TriggerTypeEvent          = "event"
+	TriggerTypeScheduled      = "scheduled"
+	TriggerTypeManual         = "manual"
+	TriggerTypeElementRequest = "elementRequest"
+)
+
+// StepProperties are the typed properties of a Formula step
+type StepProperties interface {
+	StepType() string
+}
+
+// TriggerProperties are the typed properties of a Formula trigger
+type TriggerProperties interface {
+	TriggerType() string
+}
+
+// ElementInstanceRef is an Element Instance ID or a reference to one, such as
+// ${config.crm}; it decodes from a JSON string or number, and numeric IDs
+// encode as numbers
+type ElementInstanceRef string
+
+// UnmarshalJSON accepts a string, a number or null
+func (r *ElementInstanceRef) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err := d.Decode(&v)
+	if err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case nil:
+		*r = ""
+	case string:
+		*r = ElementInstanceRef(t)
+	case json.Number:
+		*r = ElementInstanceRef(t.String())
+	default:
+		return fmt.Errorf("element instance ID must be a string or number, not %s", b)
+	}
+	return nil
+}
+
+// MarshalJSON encodes numeric IDs in canonical form, such as 123, as numbers
+// and anything else, including "007" or "+5", as strings
+func (r ElementInstanceRef) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.ParseInt(string(r), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(r) {
+		return []byte(r), nil
+	}
+	return json.Marshal(string(r))
+}
+
+// Every typed properties struct keeps the properties it does not model in
+// Extra, and omits empty fields, so decoding and encoding a step neither loses
+// nor adds anything
+
+// ScriptProperties runs JavaScript; Body calls done() with the step's result
+type ScriptProperties struct {
+	Body  string                 `json:"body,omitempty"`
+	Extra map[string]interface{} `json:"-"`
+}
+
+// FilterProperties runs JavaScript that calls done(true) or done(false) to
+// follow the step's success or failure edges
+type FilterProperties struct {
+	Body  string                 `json:"body,omitempty"`
+	Extra map[string]interface{} `json:"-"`
+}
+
+// ElementRequestProperties calls an Element Instance's API
+type ElementRequestProperties struct {
+	ElementInstanceID     ElementInstanceRef     `json:"elementInstanceId,omitempty"`
+	Method                string                 `json:"method,omitempty"`
+	API                   string                 `json:"api,omitempty"`
+	Path                  interface{}            `json:"path,omitempty"`
+	Query                 interface{}            `json:"query,omitempty"`
+	Headers               interface{}            `json:"headers,omitempty"`
+	Body                  interface{}            `json:"body,omitempty"`
+	AcceptableStatusCodes interface{}            `json:"acceptableStatusCodes,omitempty"`
+	Retry                 interface{}            `json:"retry,omitempty"`
+	RetryAttempts         interface{}            `json:"retryAttempts,omitempty"`
+	RetryDelay            interface{}            `json:"retryDelay,omitempty"`
+	RetryStatusCodes      interface{}            `json:"retryStatusCodes,omitempty"`
+	Extra                 map[string]interface{} `json:"-"`
+}
+
+// ElementRequestStreamProperties streams a download from one Element Instance
+// as an upload to another
+type ElementRequestStreamProperties struct {
+	DownloadElementInstanceID ElementInstanceRef     `json:"downloadElementInstanceId,omitempty"`
+	DownloadMethod            string                 `json:"downloadMethod,omitempty"`
+	DownloadAPI               string                 `json:"downloadApi,omitempty"`
+	UploadElementInstanceID   ElementInstanceRef     `json:"uploadElementInstanceId,omitempty"`
+	UploadMethod              string                 `json:"uploadMethod,omitempty"`
+	UploadAPI                 string                 `json:"uploadApi,omitempty"`
+	UploadFormDataName        string                 `json:"uploadFormDataName,omitempty"`
+	Extra                     map[string]interface{} `json:"-"`
+}
+
+// HTTPRequestProperties calls an arbitrary URL
+type HTTPRequestProperties struct {
+	URL                   string                 `json:"url,omitempty"`
+	Method                string                 `json:"method,omitempty"`
+	Query                 interface{}            `json:"query,omitempty"`
+	Headers               interface{}            `json:"headers,omitempty"`
+	Body                  interface{}            `json:"body,omitempty"`
+	AcceptableStatusCodes interface{}            `json:"acceptableStatusCodes,omitempty"`
+	Extra                 map[string]interface{} `json:"-"`
+}
+
+// LoopProperties iterates over List, following onSuccess for each entry and
+// onFailure when the list is exhausted
+type LoopProperties struct {
+	List  string                 `json:"list,omitempty"`
+	Extra map[string]interface{} `json:"-"`
+}
+
+// FormulaProperties executes another Formula as a sub-formula
+type FormulaProperties struct {
+	FormulaID interface{}            `json:"formulaId,omitempty"`
+	Args      interface{}            `json:"args,omitempty"`
+	Extra     map[string]interface{} `json:"-"`
+}
+
+// NotificationProperties sends an email notification
+type NotificationProperties struct {
+	Recipients string                 `json:"recipients,omitempty"`
+	Subject    string                 `json:"subject,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+	Extra      map[string]interface{} `json:"-"`
+}
+
+// RetryFormulaExecutionProperties retries the current execution
+type RetryFormulaExecutionProperties struct {
+	RetryAttempts interface{}            `json:"retryAttempts,omitempty"`
+	RetryDelay    interface{}            `json:"retryDelay,omitempty"`
+	Extra         map[string]interface{} `json:"-"`
+}
+
+// RawStepProperties holds the properties of a step type this package does not model
+type RawStepProperties struct {
+	Type       string
+	Properties json.RawMessage
+}
+
+// EventTriggerProperties starts a Formula on an Element Instance event
+type EventTriggerProperties struct {
+	ElementInstanceID ElementInstanceRef     `json:"elementInstanceId,omitempty"`
+	Extra             map[string]interface{} `json:"-"`
+}
+
+// ScheduledTriggerProperties starts a Formula on a cron schedule
+type ScheduledTriggerProperties struct {
+	Cron  string                 `json:"cron,omitempty"`
+	Extra map[string]interface{} `json:"-"`
+}
+
+// ManualTriggerProperties starts a Formula through its API or TriggerFormulaInstance
+type ManualTriggerProperties struct {
+	Extra map[string]interface{} `json:"-"`
+}
+
+// ElementRequestTriggerProperties starts a Formula when an Element Instance API is called
+type ElementRequestTriggerProperties struct {
+	ElementInstanceID ElementInstanceRef     `json:"elementInstanceId,omitempty"`
+	Method            string                 `json:"method,omitempty"`
+	API               string                 `json:"api,omitempty"`
+	Extra             map[string]interface{} `json:"-"`
+}
+
+// RawTriggerProperties holds the properties of a trigger type this package does not model
+type RawTriggerProperties struct {
+	Type       string
+	Properties json.RawMessage
+}
+
+// StepType implementations
+func (*ScriptProperties) StepType() string                { return StepTypeScript }
+func (*FilterProperties) StepType() string                { return StepTypeFilter }
+func (*ElementRequestProperties) StepType() string        { return StepTypeElementRequest }
+func (*ElementRequestStreamProperties) StepType() string  { return StepTypeElementRequestStream }
+func (*HTTPRequestProperties) StepType() string           { return StepTypeHTTPRequest }
+func (*LoopProperties) StepType() string                  { return StepTypeLoop }
+func (*FormulaProperties) StepType() string               { return StepTypeFormula }
+func (*NotificationProperties) StepType() string          { return StepTypeNotification }
+func (*RetryFormulaExecutionProperties) StepType() string { return StepTypeRetryFormulaExecution }
+func (p *RawStepProperties) StepType() string             { return p.Type }
+
+// TriggerType implementations
+func (*EventTriggerProperties) TriggerType() string          { return TriggerTypeEvent }
+func (*ScheduledTriggerProperties) TriggerType() string      { return TriggerTypeScheduled }
+func (*ManualTriggerProperties) TriggerType() string         { return TriggerTypeManual }
+func (*ElementRequestTriggerProperties) TriggerType() string { return TriggerTypeElementRequest }
+func (p *RawTriggerProperties) TriggerType() string          { return p.Type }
+
+func newStepProperties(stepType string) StepProperties {
+	switch stepType {
+	case StepTypeScript:
+		return &ScriptProperties{}
+	case StepTypeFilter:
+		return &FilterProperties{}
+	case StepTypeElementRequest:
+		return &ElementRequestProperties{}
+	case StepTypeElementRequestStream:
+		return &ElementRequestStreamProperties{}
+	case StepTypeHTTPRequest:
+		return &HTTPRequestProperties{}
+	case StepTypeLoop:
+		return &LoopProperties{}
+	case StepTypeFormula:
+		return &FormulaProperties{}
+	case StepTypeNotification:
+		return &NotificationProperties{}
+	case StepTypeRetryFormulaExecution:
+		return &RetryFormulaExecutionProperties{}
+	}
+	return nil
+}
+
+func newTriggerProperties(triggerType string) TriggerProperties {
+	switch triggerType {
+	case TriggerTypeEvent:
+		return &EventTriggerProperties{}
+	case TriggerTypeScheduled:
+		return &ScheduledTriggerProperties{}
+	case TriggerTypeManual:
+		return &ManualTriggerProperties{}
+	case TriggerTypeElementRequest:
+		return &ElementRequestTriggerProperties{}
+	}
+	return nil
+}
+
+// DecodeStepProperties decodes a step's properties into the typed struct for
+// its type; unknown types are returned as *RawStepProperties
+func DecodeStepProperties(stepType string, properties interface{}) (StepProperties, error) {
+	raw, err := rawProperties(properties)
+	if err != nil {
+		return nil, err
+	}
+	p := newStepProperties(stepType)
+	if p == nil {
+		return &RawStepProperties{Type: stepType, Properties: raw}, nil
+	}
+	err = decodeProperties(raw, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s step properties: %s", stepType, err)
+	}
+	return p, nil
+}
+
+// DecodeTriggerProperties decodes a trigger's properties into the typed struct
+// for its type; unknown types are returned as *RawTriggerProperties
+func DecodeTriggerProperties(triggerType string, properties interface{}) (TriggerProperties, error) {
+	raw, err := rawProperties(properties)
+	if err != nil {
+		return nil, err
+	}
+	p := newTriggerProperties(triggerType)
+	if p == nil {
+		return &RawTriggerProperties{Type: triggerType, Properties: raw}, nil
+	}
+	err = decodeProperties(raw, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s trigger properties: %s", triggerType, err)
+	}
+	return p, nil
+}
+
+// TypedProperties decodes the step's properties according to its Type
+func (s Step) TypedProperties() (StepProperties, error) {
+	return DecodeStepProperties(s.Type, s.Properties)
+}
+
+// TypedProperties decodes the trigger's properties according to its Type
+func (t Trigger) TypedProperties() (TriggerProperties, error) {
+	return DecodeTriggerProperties(t.Type, t.Properties)
+}
+
+// SetProperties sets the step's Type and encoded Properties
+func (s *Step) SetProperties(p StepProperties) error {
+	raw, err := EncodeProperties(p)
+	if err != nil {
+		return err
+	}
+	s.Type = p.StepType()
+	s.Properties = raw
+	return nil
+}
+
+// SetProperties sets the trigger's Type and encoded Properties
+func (t *Trigger) SetProperties(p TriggerProperties) error {
+	raw, err := EncodeProperties(p)
+	if err != nil {
+		return err
+	}
+	t.Type = p.TriggerType()
+	t.Properties = raw
+	return nil
+}
+
+// NewStep returns a step of the properties' type
+func NewStep(name string, p StepProperties, onSuccess, onFailure []string) (Step, error) {
+	s := Step{Name: name, OnSuccess: onSuccess, OnFailure: onFailure}
+	err := s.SetProperties(p)
+	return s, err
+}
+
+// EncodeProperties encodes typed step or trigger properties, including any
+// Extra properties, as the JSON the Platform expects
+func EncodeProperties(p interface{}) (json.RawMessage, error) {
+	switch t := p.(type) {
+	case *RawStepProperties:
+		return rawOrEmpty(t.Properties), nil
+	case *RawTriggerProperties:
+		return rawOrEmpty(t.Properties), nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	extra := extraField(p)
+	if !extra.IsValid() || extra.Len() == 0 {
+		return json.RawMessage(b), nil
+	}
+	var merged map[string]interface{}
+	err = json.Unmarshal(b, &merged)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range extra.MapKeys() {
+		if _, ok := merged[k.String()]; !ok {
+			merged[k.String()] = extra.MapIndex(k).Interface()
+		}
+	}
+	b, err = json.Marshal(merged)
+	return json.RawMessage(b), err
+}
+
+func rawOrEmpty(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("{}")
+	}
+	return raw
+}
+
+// rawProperties returns properties as JSON, whether they were decoded from
+// the Platform, built as a map or are already JSON
+func rawProperties(properties interface{}) (json.RawMessage, error) {
+	switch t := properties.(type) {
+	case nil:
+		return json.RawMessage("{}"), nil
+	case json.RawMessage:
+		return t, nil
+	}
+	b, err := json.Marshal(properties)
+	return json.RawMessage(b), err
+}
+
+// decodeProperties unmarshals raw into p, keeping properties p does not
+// declare in its Extra field
+func decodeProperties(raw json.RawMessage, p interface{}) error {
+	err := json.Unmarshal(raw, p)
+	if err != nil {
+		return err
+	}
+	var all map[string]interface{}
+	err = json.Unmarshal(raw, &all)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	t := reflect.TypeOf(p).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	extra := make(map[string]interface{})
+	for k, v := range all {
+		if !known[k] {
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		extraField(p).Set(reflect.ValueOf(extra))
+	}
+	return nil
+}
+
+func extraField(p interface{}) reflect.Value {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return v.Elem().FieldByName("Extra")
+}
@@ -0,0 +1,136 @@
+package ce
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStepTypedProperties(t *testing.T) {
+	var f Formula
+	err := json.Unmarshal([]byte(`{
+		"name": "typed",
+		"triggers": [{"type": "scheduled", "properties": {"cron": "0 0 * * * ?"}}],
+		"steps": [
+			{"name": "get", "type": "elementRequest", "properties": {"elementInstanceId": "${config.crm}", "method": "GET", "api": "/hubs/crm/contacts", "query": {"where": "x"}, "custom": 3}},
+			{"name": "run", "type": "script", "properties": {"body": "done({});"}},
+			{"name": "odd", "type": "amqpRequest", "properties": {"queue": "q"}}
+		]
+	}`), &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := f.Steps[0].TypedProperties()
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	er, ok := p.(*ElementRequestProperties)
+	if !ok || er.API != "/hubs/crm/contacts" || er.ElementInstanceID != "${config.crm}" || er.Extra["custom"] != float64(3) {
+		t.Errorf("unexpected properties %#v", p)
+	}
+	raw, err := EncodeProperties(er)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(diffJSON("", f.Steps[0].Properties, raw)) != 0 {
+		t.Errorf("round trip changed properties: %s", raw)
+	}
+
+	p, _ = f.Steps[1].TypedProperties()
+	if s, ok := p.(*ScriptProperties); !ok || s.Body != "done({});" {
+		t.Errorf("unexpected properties %#v", p)
+	}
+
+	p, _ = f.Steps[2].TypedProperties()
+	rp, ok := p.(*RawStepProperties)
+	if !ok || rp.StepType() != "amqpRequest" || string(rp.Properties) != `{"queue":"q"}` {
+		t.Errorf("unexpected properties %#v", p)
+	}
+
+	tp, err := f.Triggers[0].TypedProperties()
+	if s, ok := tp.(*ScheduledTriggerProperties); err != nil || !ok || s.Cron != "0 0 * * * ?" {
+		t.Errorf("unexpected trigger properties %#v %v", tp, err)
+	}
+
+	step, err := NewStep("notify", &NotificationProperties{Recipients: "ops@example.com", Subject: "s", Message: "m"}, nil, nil)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	b, _ := json.Marshal(step)
+	if step.Type != StepTypeNotification || string(b) != `{"id":0,"onSuccess":null,"onFailure":null,"name":"notify","type":"notification","properties":{"recipients":"ops@example.com","subject":"s","message":"m"}}` {
+		t.Errorf("unexpected step %s", b)
+	}
+
+	_, err = DecodeStepProperties(StepTypeScript, map[string]interface{}{"body": 3})
+	if err == nil {
+		t.Errorf("expected error decoding a numeric script body")
+	}
+}
+
+func TestNumericElementInstanceIDs(t *testing.T) {
+	var f Formula
+	err := json.Unmarshal([]byte(`{
+		"name": "numeric",
+		"triggers": [{"type": "event", "properties": {"elementInstanceId": 456}}],
+		"steps": [
+			{"name": "get", "type": "elementRequest", "properties": {"elementInstanceId": 123, "method": "GET", "api": "/hubs/crm/contacts"}},
+			{"name": "copy", "type": "elementRequestStream", "properties": {"downloadElementInstanceId": 1, "downloadMethod": "GET", "downloadApi": "/files", "uploadElementInstanceId": "${config.target}", "uploadMethod": "POST", "uploadApi": "/files"}}
+		]
+	}`), &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := f.Steps[0].TypedProperties()
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	er := p.(*ElementRequestProperties)
+	if er.ElementInstanceID != "123" {
+		t.Errorf("unexpected element instance ID %q", er.ElementInstanceID)
+	}
+	raw, err := EncodeProperties(er)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(diffJSON("", f.Steps[0].Properties, raw)) != 0 {
+		t.Errorf("round trip changed properties: %s", raw)
+	}
+
+	p, err = f.Steps[1].TypedProperties()
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if s := p.(*ElementRequestStreamProperties); s.DownloadElementInstanceID != "1" || s.UploadElementInstanceID != "${config.target}" {
+		t.Errorf("unexpected stream properties %#v", s)
+	}
+
+	tp, err := f.Triggers[0].TypedProperties()
+	if e, ok := tp.(*EventTriggerProperties); err != nil || !ok || e.ElementInstanceID != "456" {
+		t.Errorf("unexpected trigger properties %#v %v", tp, err)
+	}
+}
+
+func TestElementInstanceRefJSON(t *testing.T) {
+	for ref, want := range map[ElementInstanceRef]string{
+		"123":                  `123`,
+		"-4":                   `-4`,
+		"007":                  `"007"`,
+		"+5":                   `"+5"`,
+		"${config.crm}":        `"${config.crm}"`,
+		"99999999999999999999": `"99999999999999999999"`,
+	} {
+		b, err := json.Marshal(ref)
+		if err != nil || string(b) != want {
+			t.Errorf("%q: expected %s, got %s %v", ref, want, b, err)
+		}
+	}
+
+	raw, err := EncodeProperties(&ElementRequestProperties{ElementInstanceID: "007", API: "/hubs/crm/contacts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `{"elementInstanceId":"007","api":"/hubs/crm/contacts"}` {
+		t.Errorf("unexpected properties %s", raw)
+	}
+}
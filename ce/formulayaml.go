@@ -0,0 +1,257 @@
+package ce
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// formulaYAML is the YAML authoring representation of a Formula; steps are a
+// mapping keyed by step name, in execution order
+type formulaYAML struct {
+	ID                  int                 `yaml:"id,omitempty"`
+	Name                string              `yaml:"name"`
+	Description         string              `yaml:"description,omitempty"`
+	UserID              int                 `yaml:"userId,omitempty"`
+	AccountID           int                 `yaml:"accountId,omitempty"`
+	CreatedDate         string              `yaml:"createdDate,omitempty"`
+	Active              bool                `yaml:"active"`
+	API                 string              `yaml:"api,omitempty"`
+	Method              string              `yaml:"method,omitempty"`
+	URI                 string              `yaml:"uri,omitempty"`
+	Engine              string              `yaml:"engine,omitempty"`
+	DebugLoggingEnabled bool                `yaml:"debugLoggingEnabled,omitempty"`
+	SingleThreaded      bool                `yaml:"singleThreaded,omitempty"`
+	Configuration       []configurationYAML `yaml:"configuration,omitempty"`
+	Triggers            []triggerYAML       `yaml:"triggers,omitempty"`
+	Steps               stepsYAML           `yaml:"steps,omitempty"`
+}
+
+type configurationYAML struct {
+	ID       int    `yaml:"id,omitempty"`
+	Key      string `yaml:"key"`
+	Name     string `yaml:"name,omitempty"`
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required,omitempty"`
+}
+
+type triggerYAML struct {
+	ID         int         `yaml:"id,omitempty"`
+	Name       string      `yaml:"name,omitempty"`
+	Type       string      `yaml:"type"`
+	Async      bool        `yaml:"async,omitempty"`
+	OnSuccess  []string    `yaml:"onSuccess,omitempty,flow"`
+	OnFailure  []string    `yaml:"onFailure,omitempty,flow"`
+	Properties interface{} `yaml:"properties,omitempty"`
+}
+
+type stepYAML struct {
+	ID         int         `yaml:"id,omitempty"`
+	Type       string      `yaml:"type"`
+	OnSuccess  []string    `yaml:"onSuccess,omitempty,flow"`
+	OnFailure  []string    `yaml:"onFailure,omitempty,flow"`
+	Properties interface{} `yaml:"properties,omitempty"`
+}
+
+// stepsYAML is an ordered list of steps written as a mapping of step name to step
+type stepsYAML []Step
+
+// MarshalYAML writes steps as an ordered mapping keyed by name
+func (s stepsYAML) MarshalYAML() (interface{}, error) {
+	m := make(yaml.MapSlice, 0, len(s))
+	for _, step := range s {
+		m = append(m, yaml.MapItem{
+			Key: step.Name,
+			Value: stepYAML{
+				ID:         step.ID,
+				Type:       step.Type,
+				OnSuccess:  step.OnSuccess,
+				OnFailure:  step.OnFailure,
+				Properties: step.Properties,
+			},
+		})
+	}
+	return m, nil
+}
+
+// UnmarshalYAML reads steps from a mapping keyed by name, keeping their order
+func (s *stepsYAML) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m yaml.MapSlice
+	err := unmarshal(&m)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, item := range m {
+		name := fmt.Sprintf("%v", item.Key)
+		if seen[name] {
+			return fmt.Errorf("duplicate step %q", name)
+		}
+		seen[name] = true
+		// re-decode the generic value into a step
+		b, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+		var sy stepYAML
+		err = yaml.UnmarshalStrict(b, &sy)
+		if err != nil {
+			return fmt.Errorf("step %s: %s", name, err)
+		}
+		*s = append(*s, Step{
+			ID:         sy.ID,
+			Name:       name,
+			Type:       sy.Type,
+			OnSuccess:  nonNil(sy.OnSuccess),
+			OnFailure:  nonNil(sy.OnFailure),
+			Properties: yamlToJSON(sy.Properties),
+		})
+	}
+	return nil
+}
+
+// MarshalFormulaYAML returns the YAML authoring representation of a Formula.
+// Steps are written as a mapping keyed by name and multi-line scripts as
+// literal blocks; Formula Instances are not included.
+func MarshalFormulaYAML(f Formula) ([]byte, error) {
+	fy := formulaYAML{
+		ID:                  f.ID,
+		Name:                f.Name,
+		Description:         f.Description,
+		UserID:              f.UserID,
+		AccountID:           f.AccountID,
+		Active:              f.Active,
+		API:                 f.API,
+		Method:              f.Method,
+		URI:                 f.URI,
+		Engine:              f.Engine,
+		DebugLoggingEnabled: f.DebugLoggingEnabled,
+		SingleThreaded:      f.SingleThreaded,
+		Steps:               stepsYAML(f.Steps),
+	}
+	if !f.CreatedDate.IsZero() {
+		fy.CreatedDate = f.CreatedDate.Format(time.RFC3339Nano)
+	}
+	for _, c := range f.Configuration {
+		fy.Configuration = append(fy.Configuration, configurationYAML(c))
+	}
+	for _, t := range f.Triggers {
+		fy.Triggers = append(fy.Triggers, triggerYAML{
+			ID:         t.ID,
+			Name:       t.Name,
+			Type:       t.Type,
+			Async:      t.Async,
+			OnSuccess:  t.OnSuccess,
+			OnFailure:  t.OnFailure,
+			Properties: t.Properties,
+		})
+	}
+	return yaml.Marshal(fy)
+}
+
+// UnmarshalFormulaYAML parses the YAML authoring representation of a Formula
+// into the Formula the platform expects; unknown fields are an error
+func UnmarshalFormulaYAML(data []byte) (Formula, error) {
+	var f Formula
+	var fy formulaYAML
+	err := yaml.UnmarshalStrict(data, &fy)
+	if err != nil {
+		return f, err
+	}
+	f = Formula{
+		ID:                  fy.ID,
+		Name:                fy.Name,
+		Description:         fy.Description,
+		UserID:              fy.UserID,
+		AccountID:           fy.AccountID,
+		Active:              fy.Active,
+		API:                 fy.API,
+		Method:              fy.Method,
+		URI:                 fy.URI,
+		Engine:              fy.Engine,
+		DebugLoggingEnabled: fy.DebugLoggingEnabled,
+		SingleThreaded:      fy.SingleThreaded,
+		Steps:               []Step(fy.Steps),
+		Triggers:            []Trigger{},
+		Configuration:       []Configuration{},
+	}
+	if f.Steps == nil {
+		f.Steps = []Step{}
+	}
+	if fy.CreatedDate != "" {
+		f.CreatedDate, err = time.Parse(time.RFC3339Nano, fy.CreatedDate)
+		if err != nil {
+			return f, fmt.Errorf("createdDate: %s", err)
+		}
+	}
+	for _, c := range fy.Configuration {
+		f.Configuration = append(f.Configuration, Configuration(c))
+	}
+	for _, t := range fy.Triggers {
+		f.Triggers = append(f.Triggers, Trigger{
+			ID:         t.ID,
+			Name:       t.Name,
+			Type:       t.Type,
+			Async:      t.Async,
+			OnSuccess:  nonNil(t.OnSuccess),
+			OnFailure:  nonNil(t.OnFailure),
+			Properties: yamlToJSON(t.Properties),
+		})
+	}
+	return f, nil
+}
+
+// FormulaJSONToYAML converts Formula JSON, as returned by the platform, to YAML
+func FormulaJSONToYAML(data []byte) ([]byte, error) {
+	var f Formula
+	err := json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalFormulaYAML(f)
+}
+
+// FormulaYAMLToJSON converts Formula YAML to the JSON the platform expects
+func FormulaYAMLToJSON(data []byte) ([]byte, error) {
+	f, err := UnmarshalFormulaYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// yamlToJSON converts a decoded YAML value, which may contain maps with
+// non-string keys, into the same value as decoded from JSON
+func yamlToJSON(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return normalizeJSON(stringKeys(v))
+}
+
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprintf("%v", k)] = stringKeys(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			l[i] = stringKeys(val)
+		}
+		return l
+	}
+	return v
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package ce
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yamlTestFormula = `{
+	"id": 42, "name": "sync contacts", "description": "", "userId": 7, "accountId": 3,
+	"createdDate": "2018-06-01T10:20:30.5Z", "active": true, "api": "", "debugLoggingEnabled": false, "singleThreaded": true,
+	"configuration": [{"id": 1, "key": "source", "name": "Source", "type": "elementInstance", "required": true}],
+	"triggers": [{"id": 5, "type": "event", "async": true, "name": "trigger", "onSuccess": ["build payload"], "onFailure": [], "properties": {"elementInstanceId": "${source}"}}],
+	"steps": [
+		{"id": 11, "name": "build payload", "type": "script", "onSuccess": ["send"], "onFailure": [], "properties": {"body": "var c = trigger.event;\ndone({id: c.objectId});\n"}},
+		{"id": 12, "name": "send", "type": "elementRequest", "onSuccess": [], "onFailure": [], "properties": {"elementInstanceId": "${source}", "method": "GET", "api": "/hubs/crm/contacts", "retryAttempts": 3, "acceptableStatusCodes": [200, 404]}}
+	]
+}`
+
+func TestFormulaYAMLRoundTrip(t *testing.T) {
+	out, err := FormulaJSONToYAML([]byte(yamlTestFormula))
+	if err != nil {
+		t.Fatalf("to yaml: %s", err)
+	}
+	if !strings.Contains(string(out), "  build payload:\n") || !strings.Contains(string(out), "body: |\n") {
+		t.Errorf("expected steps keyed by name and literal script block, got\n%s", out)
+	}
+	if strings.Index(string(out), "build payload:") > strings.Index(string(out), "send:") {
+		t.Errorf("step order not preserved\n%s", out)
+	}
+
+	back, err := FormulaYAMLToJSON(out)
+	if err != nil {
+		t.Fatalf("to json: %s", err)
+	}
+	var want, got interface{}
+	json.Unmarshal([]byte(yamlTestFormula), &want)
+	json.Unmarshal(back, &got)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("round trip not lossless\nwant %v\ngot  %v", want, got)
+	}
+}
+
+func TestUnmarshalFormulaYAML(t *testing.T) {
+	doc := `# contacts sync, reviewed in git
+name: hand written
+active: false
+triggers:
+  - type: manual
+    onSuccess: [greet]
+steps:
+  greet:           # first step
+    type: script
+    onSuccess: [check]
+    properties:
+      body: |
+        // say hello
+        done({greeting: "hi"});
+  check:
+    type: filter
+    properties:
+      body: done(true);
+`
+	f, err := UnmarshalFormulaYAML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Steps) != 2 || f.Steps[0].Name != "greet" || f.Steps[1].Name != "check" {
+		t.Fatalf("unexpected steps %+v", f.Steps)
+	}
+	p, err := f.Steps[0].TypedProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := p.(*ScriptProperties); !ok || s.Body != "// say hello\ndone({greeting: \"hi\"});\n" {
+		t.Errorf("unexpected script properties %#v", p)
+	}
+	if f.Steps[1].OnSuccess == nil || f.Triggers[0].OnFailure == nil {
+		t.Errorf("expected empty, not nil, step references")
+	}
+	if diags := LintFormula(f); LintHasErrors(diags) {
+		t.Errorf("unexpected lint errors %v", diags)
+	}
+
+	_, err = UnmarshalFormulaYAML([]byte("name: dup\nsteps:\n  a:\n    type: script\n  a:\n    type: script\n"))
+	if err == nil {
+		t.Errorf("expected duplicate step error")
+	}
+	_, err = UnmarshalFormulaYAML([]byte("name: typo\nstpes: {}\n"))
+	if err == nil {
+		t.Errorf("expected unknown field error")
+	}
+}
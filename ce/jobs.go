@@ -1,7 +1,15 @@
 package ce
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// JobsURI is the base URI for scheduled jobs
+	JobsURI = "/jobs"
 )
 
 // Job represents an scheduled job on the platform
@@ -37,7 +45,7 @@ type JobTrigger struct {
 
 // ListJobs lists jobs on the Platform
 func ListJobs(base, auth string) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s", base, "/jobs")
+	url := fmt.Sprintf("%s%s", base, JobsURI)
 	return Execute("GET", url, auth)
 }
 
@@ -49,6 +57,199 @@ func DeleteJob(base, auth string, jobID string) ([]byte, int, string, error) {
 
 // CreateJob creates a job from a JSON body
 func CreateJob(base, auth string, body []byte) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s", base, "/jobs")
+	url := fmt.Sprintf("%s%s", base, JobsURI)
 	return ExecuteWithBody("POST", url, auth, body)
 }
+
+// JobDefinition is the request body used to create a scheduled job
+type JobDefinition struct {
+	Name               string            `json:"name"`
+	Description        string            `json:"description,omitempty"`
+	DisallowConcurrent bool              `json:"disallowConcurrent,omitempty"`
+	Trigger            JobCronTrigger    `json:"trigger"`
+	Method             string            `json:"method"`
+	URI                string            `json:"uri"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	Query              map[string]string `json:"query,omitempty"`
+	Body               interface{}       `json:"body,omitempty"`
+	Notifications      *JobNotifications `json:"notifications,omitempty"`
+}
+
+// JobCronTrigger is the schedule of a job, as a Quartz cron expression
+type JobCronTrigger struct {
+	Cron string `json:"cron"`
+}
+
+// JobNotifications are the notifications sent when a job runs
+type JobNotifications struct {
+	Emails    []string `json:"emails,omitempty"`
+	OnSuccess bool     `json:"onSuccess,omitempty"`
+	OnFailure bool     `json:"onFailure,omitempty"`
+}
+
+// JobBuilder constructs a JobDefinition for use with CreateJob
+type JobBuilder struct {
+	job JobDefinition
+}
+
+// NewJobBuilder starts a job definition with the given name
+func NewJobBuilder(name string) *JobBuilder {
+	return &JobBuilder{job: JobDefinition{Name: name}}
+}
+
+// Description sets the job's description
+func (b *JobBuilder) Description(description string) *JobBuilder {
+	b.job.Description = description
+	return b
+}
+
+// Cron sets the job's schedule, a Quartz cron expression such as "0 0/15 * 1/1 * ? *"
+func (b *JobBuilder) Cron(expression string) *JobBuilder {
+	b.job.Trigger.Cron = expression
+	return b
+}
+
+// DisallowConcurrent prevents the job from running while a previous run is in progress
+func (b *JobBuilder) DisallowConcurrent(disallow bool) *JobBuilder {
+	b.job.DisallowConcurrent = disallow
+	return b
+}
+
+// CallURI schedules a call to a Platform URI, such as GET /hubs/crm/contacts
+func (b *JobBuilder) CallURI(method, uri string) *JobBuilder {
+	b.job.Method = strings.ToUpper(method)
+	b.job.URI = uri
+	return b
+}
+
+// Header adds a header sent with the scheduled call, for example an
+// Elements-Formula-Instance-Id or an Element Instance token
+func (b *JobBuilder) Header(key, value string) *JobBuilder {
+	if b.job.Headers == nil {
+		b.job.Headers = make(map[string]string)
+	}
+	b.job.Headers[key] = value
+	return b
+}
+
+// Query adds a query parameter sent with the scheduled call
+func (b *JobBuilder) Query(key, value string) *JobBuilder {
+	if b.job.Query == nil {
+		b.job.Query = make(map[string]string)
+	}
+	b.job.Query[key] = value
+	return b
+}
+
+// Body sets the JSON body sent with the scheduled call
+func (b *JobBuilder) Body(body interface{}) *JobBuilder {
+	b.job.Body = body
+	return b
+}
+
+// TriggerFormulaInstance schedules an execution of a manual-trigger Formula Instance,
+// with the given trigger body (may be nil)
+func (b *JobBuilder) TriggerFormulaInstance(formulaInstanceID int, triggerBody interface{}) *JobBuilder {
+	b.job.Method = "POST"
+	b.job.URI = fmt.Sprintf(FormulaExecutionsURIFormat, strconv.Itoa(formulaInstanceID))
+	if triggerBody == nil {
+		triggerBody = struct{}{}
+	}
+	b.job.Body = triggerBody
+	return b
+}
+
+// NotifyEmails sets the email addresses notified when the job succeeds and/or fails
+func (b *JobBuilder) NotifyEmails(onSuccess, onFailure bool, emails ...string) *JobBuilder {
+	b.job.Notifications = &JobNotifications{
+		Emails:    emails,
+		OnSuccess: onSuccess,
+		OnFailure: onFailure,
+	}
+	return b
+}
+
+// Definition validates and returns the job definition
+func (b *JobBuilder) Definition() (JobDefinition, error) {
+	err := b.job.Validate()
+	if err != nil {
+		return b.job, err
+	}
+	return b.job, nil
+}
+
+// Build validates the job definition and returns the request body for CreateJob
+func (b *JobBuilder) Build() ([]byte, error) {
+	job, err := b.Definition()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(job)
+}
+
+// Validate checks that a job definition can be sent to the Platform
+func (j JobDefinition) Validate() error {
+	if strings.TrimSpace(j.Name) == "" {
+		return fmt.Errorf("job name is required")
+	}
+	switch j.Method {
+	case "GET", "POST", "PUT", "PATCH", "DELETE":
+	case "":
+		return fmt.Errorf("job %s has no method, use CallURI or TriggerFormulaInstance", j.Name)
+	default:
+		return fmt.Errorf("job %s has unsupported method %s", j.Name, j.Method)
+	}
+	if !strings.HasPrefix(j.URI, "/") {
+		return fmt.Errorf("job %s URI must be a Platform path starting with /, got %q", j.Name, j.URI)
+	}
+	if (j.Method == "GET" || j.Method == "DELETE") && j.Body != nil {
+		return fmt.Errorf("job %s cannot send a body with %s", j.Name, j.Method)
+	}
+	err := ValidateCron(j.Trigger.Cron)
+	if err != nil {
+		return fmt.Errorf("job %s: %s", j.Name, err)
+	}
+	if j.Notifications != nil {
+		if len(j.Notifications.Emails) == 0 {
+			return fmt.Errorf("job %s notifications need at least one email", j.Name)
+		}
+		if !j.Notifications.OnSuccess && !j.Notifications.OnFailure {
+			return fmt.Errorf("job %s notifications need onSuccess and/or onFailure", j.Name)
+		}
+		for _, e := range j.Notifications.Emails {
+			if !strings.Contains(e, "@") {
+				return fmt.Errorf("job %s notification email %q is invalid", j.Name, e)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateCron checks the shape of a Quartz cron expression: six or seven
+// fields (seconds through optional year), exactly one of day-of-month or
+// day-of-week being "?"
+func ValidateCron(expression string) error {
+	fields := strings.Fields(expression)
+	if len(fields) == 0 {
+		return fmt.Errorf("cron expression is required")
+	}
+	if len(fields) < 6 || len(fields) > 7 {
+		return fmt.Errorf("cron expression %q must have 6 or 7 fields, has %v", expression, len(fields))
+	}
+	for i, f := range fields {
+		for _, c := range f {
+			if strings.ContainsRune("0123456789*?/,-#LW", c) {
+				continue
+			}
+			if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+				// month and day names, such as JAN or MON-FRI
+				continue
+			}
+			return fmt.Errorf("cron expression %q has invalid character %q in field %v", expression, c, i+1)
+		}
+	}
+	if (fields[3] == "?") == (fields[5] == "?") {
+		return fmt.Errorf("cron expression %q must use ? for exactly one of day-of-month or day-of-week", expression)
+	}
+	return nil
+}
@@ -98,3 +98,53 @@ func TestDeleteJob(t *testing.T) {
 		t.Errorf("non-200 error code %v", code)
 	}
 }
+
+func TestJobBuilder(t *testing.T) {
+	jobbytes, err := NewJobBuilder("Test Job").
+		Description("My test job").
+		Cron("0 0/15 * 1/1 * ? *").
+		CallURI("get", "/elements/api-v2/instances").
+		Build()
+	if err != nil {
+		t.Errorf("error: %s", err)
+	}
+	var built JobDefinition
+	err = json.Unmarshal(jobbytes, &built)
+	if err != nil {
+		t.Errorf("unable to parse body: %s", err)
+	}
+	if built.Method != "GET" || built.URI != "/elements/api-v2/instances" || built.Trigger.Cron != "0 0/15 * 1/1 * ? *" {
+		t.Errorf("unexpected job %+v", built)
+	}
+
+	def, err := NewJobBuilder("Formula Job").
+		Cron("0 0 12 * * ?").
+		TriggerFormulaInstance(199701, nil).
+		DisallowConcurrent(true).
+		NotifyEmails(false, true, "ops@example.com").
+		Definition()
+	if err != nil {
+		t.Errorf("error: %s", err)
+	}
+	if def.URI != "/formulas/instances/199701/executions" || def.Method != "POST" {
+		t.Errorf("unexpected formula job %+v", def)
+	}
+
+	invalid := []*JobBuilder{
+		NewJobBuilder("").Cron("0 0 12 * * ?").CallURI("GET", "/hubs"),
+		NewJobBuilder("no cron").CallURI("GET", "/hubs"),
+		NewJobBuilder("bad cron").Cron("0 0 12 * *").CallURI("GET", "/hubs"),
+		NewJobBuilder("no ?").Cron("0 0 12 * * *").CallURI("GET", "/hubs"),
+		NewJobBuilder("two ?").Cron("0 0 12 ? * ?").CallURI("GET", "/hubs"),
+		NewJobBuilder("bad method").Cron("0 0 12 * * ?").CallURI("HEAD", "/hubs"),
+		NewJobBuilder("bad uri").Cron("0 0 12 * * ?").CallURI("GET", "hubs"),
+		NewJobBuilder("get body").Cron("0 0 12 * * ?").CallURI("GET", "/hubs").Body("x"),
+		NewJobBuilder("no emails").Cron("0 0 12 * * ?").CallURI("GET", "/hubs").NotifyEmails(true, true),
+	}
+	for _, b := range invalid {
+		_, err := b.Build()
+		if err == nil {
+			t.Errorf("expected error for job %q", b.job.Name)
+		}
+	}
+}
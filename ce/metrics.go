@@ -1,10 +1,15 @@
 package ce
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+// Metrics API URIs
 const (
 	MetricsAPI                     = "/metrics/api"
 	MetricsBulkJobsAPI             = "/metrics/bulk-jobs"
@@ -19,6 +24,130 @@ const (
 	MetricsHubsCreated             = "/metrics/hubs-created"
 )
 
+// Metrics intervals accepted by the Platform
+const (
+	MetricsIntervalHour  = "hour"
+	MetricsIntervalDay   = "day"
+	MetricsIntervalWeek  = "week"
+	MetricsIntervalMonth = "month"
+)
+
+// MetricsQuery narrows a metrics request by time range and filters;
+// zero values are omitted, so MetricsQuery{} returns the Platform defaults
+type MetricsQuery struct {
+	From        time.Time
+	To          time.Time
+	Interval    string
+	AccountIDs  []int
+	ElementKeys []string
+	InstanceIDs []int
+}
+
+// Values returns the query parameters for a metrics request
+func (q MetricsQuery) Values() url.Values {
+	v := url.Values{}
+	if !q.From.IsZero() {
+		v.Set("from", q.From.UTC().Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		v.Set("to", q.To.UTC().Format(time.RFC3339))
+	}
+	if q.Interval != "" {
+		v.Set("interval", q.Interval)
+	}
+	for _, id := range q.AccountIDs {
+		v.Add("accountIds[]", strconv.Itoa(id))
+	}
+	for _, key := range q.ElementKeys {
+		v.Add("elementKeys[]", key)
+	}
+	for _, id := range q.InstanceIDs {
+		v.Add("instanceIds[]", strconv.Itoa(id))
+	}
+	return v
+}
+
+// metricsURL returns the full URL of a metrics endpoint with the query encoded
+func metricsURL(base, uri string, q MetricsQuery) string {
+	u := fmt.Sprintf("%s%s", base, uri)
+	encoded := q.Values().Encode()
+	if encoded != "" {
+		u = fmt.Sprintf("%s?%s", u, encoded)
+	}
+	return u
+}
+
+// APIMetric is the count of API calls for an Element Instance in a time bucket
+type APIMetric struct {
+	Timestamp  time.Time `json:"timestamp"`
+	AccountID  int       `json:"accountId"`
+	ElementKey string    `json:"elementKey"`
+	InstanceID int       `json:"instanceId"`
+	Count      int       `json:"count"`
+	Success    int       `json:"success"`
+	Failed     int       `json:"failed"`
+}
+
+// BulkJobMetric is the count of bulk jobs for an Element Instance in a time bucket
+type BulkJobMetric struct {
+	Timestamp  time.Time `json:"timestamp"`
+	AccountID  int       `json:"accountId"`
+	ElementKey string    `json:"elementKey"`
+	InstanceID int       `json:"instanceId"`
+	Count      int       `json:"count"`
+	Records    int       `json:"records"`
+	Failed     int       `json:"failed"`
+}
+
+// EventMetric is the count of events received for an Element Instance in a time bucket
+type EventMetric struct {
+	Timestamp  time.Time `json:"timestamp"`
+	AccountID  int       `json:"accountId"`
+	ElementKey string    `json:"elementKey"`
+	InstanceID int       `json:"instanceId"`
+	Count      int       `json:"count"`
+}
+
+// FormulaExecutionMetric is the count of executions of a Formula Instance in a time bucket
+type FormulaExecutionMetric struct {
+	Timestamp         time.Time `json:"timestamp"`
+	AccountID         int       `json:"accountId"`
+	FormulaID         int       `json:"formulaId"`
+	FormulaInstanceID int       `json:"formulaInstanceId"`
+	Count             int       `json:"count"`
+	Success           int       `json:"success"`
+	Failed            int       `json:"failed"`
+	Cancelled         int       `json:"cancelled"`
+}
+
+// VDRMetric is the count of Virtual Data Resource invocations in a time bucket
+type VDRMetric struct {
+	Timestamp  time.Time `json:"timestamp"`
+	AccountID  int       `json:"accountId"`
+	ObjectName string    `json:"objectName"`
+	ElementKey string    `json:"elementKey"`
+	InstanceID int       `json:"instanceId"`
+	Count      int       `json:"count"`
+}
+
+// HubMetric is the count of hub API calls for an Element Instance in a time bucket
+type HubMetric struct {
+	Timestamp  time.Time `json:"timestamp"`
+	AccountID  int       `json:"accountId"`
+	Hub        string    `json:"hub"`
+	ElementKey string    `json:"elementKey"`
+	InstanceID int       `json:"instanceId"`
+	Count      int       `json:"count"`
+}
+
+// CreatedMetric is the count of resources (elements, instances, formulas,
+// VDRs, hubs) created by an account in a time bucket
+type CreatedMetric struct {
+	Timestamp time.Time `json:"timestamp"`
+	AccountID int       `json:"accountId"`
+	Count     int       `json:"count"`
+}
+
 // GetJSONMetricsFor provides JSON return for the provided url
 func GetJSONMetricsFor(url string, base, auth string, debug bool) ([]byte, int, string, error) {
 	if debug {
@@ -42,90 +171,179 @@ func GetJSONMetricsFor(url string, base, auth string, debug bool) ([]byte, int,
 
 // GetMetricsHubAPI returns raw JSON metrics
 func GetMetricsHubAPI(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s", base, MetricsHubAPI)
+	return GetMetricsHubAPIWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsHubAPIWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsHubAPIWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsHubAPI, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsHubsCreated returns raw JSON metrics
 func GetMetricsHubsCreated(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s", base, MetricsHubsCreated)
+	return GetMetricsHubsCreatedWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsHubsCreatedWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsHubsCreatedWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsHubsCreated, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsVDRsInvoked returns raw JSON metrics
 func GetMetricsVDRsInvoked(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsVDRsInvoked,
-	)
+	return GetMetricsVDRsInvokedWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsVDRsInvokedWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsVDRsInvokedWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsVDRsInvoked, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsVDRsCreated returns raw JSON metrics
 func GetMetricsVDRsCreated(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsVDRsCreated,
-	)
+	return GetMetricsVDRsCreatedWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsVDRsCreatedWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsVDRsCreatedWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsVDRsCreated, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsFormulasCreated returns raw JSON metrics
 func GetMetricsFormulasCreated(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsFormulasCreated,
-	)
+	return GetMetricsFormulasCreatedWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsFormulasCreatedWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsFormulasCreatedWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsFormulasCreated, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsFormulaExecutions returns raw JSON metrics
 func GetMetricsFormulaExecutions(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsFormulaExecutions,
-	)
+	return GetMetricsFormulaExecutionsWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsFormulaExecutionsWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsFormulaExecutionsWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsFormulaExecutions, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsEvents returns raw JSON metrics
 func GetMetricsEvents(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsEvents,
-	)
+	return GetMetricsEventsWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsEventsWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsEventsWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsEvents, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsElementsCreated returns raw JSON metrics
 func GetMetricsElementsCreated(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsElementsCreated,
-	)
+	return GetMetricsElementsCreatedWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsElementsCreatedWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsElementsCreatedWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsElementsCreated, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsElementInstancesCreated returns raw JSON metrics
 func GetMetricsElementInstancesCreated(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s", base, MetricsElementInstancesCreated)
+	return GetMetricsElementInstancesCreatedWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsElementInstancesCreatedWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsElementInstancesCreatedWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsElementInstancesCreated, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetricsBulkJobs returns raw JSON metrics
 func GetMetricsBulkJobs(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsBulkJobsAPI,
-	)
+	return GetMetricsBulkJobsWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsBulkJobsWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsBulkJobsWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsBulkJobsAPI, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
 
 // GetMetrics returns raw JSON metrics
 func GetMetrics(base, auth string, debug bool) ([]byte, int, string, error) {
-	url := fmt.Sprintf("%s%s",
-		base,
-		MetricsAPI,
-	)
+	return GetMetricsWithQuery(base, auth, MetricsQuery{}, debug)
+}
+
+// GetMetricsWithQuery returns raw JSON metrics for the query's time range and filters
+func GetMetricsWithQuery(base, auth string, q MetricsQuery, debug bool) ([]byte, int, string, error) {
+	url := metricsURL(base, MetricsAPI, q)
 	return GetJSONMetricsFor(url, base, auth, debug)
 }
+
+// decodeMetrics unmarshals a successful metrics response into v
+func decodeMetrics(bodybytes []byte, err error, v interface{}) error {
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bodybytes, v)
+}
+
+// GetAPIMetrics returns API call metrics
+func GetAPIMetrics(base, auth string, q MetricsQuery, debug bool) ([]APIMetric, error) {
+	var metrics []APIMetric
+	bodybytes, _, _, err := GetMetricsWithQuery(base, auth, q, debug)
+	return metrics, decodeMetrics(bodybytes, err, &metrics)
+}
+
+// GetBulkJobMetrics returns bulk job metrics
+func GetBulkJobMetrics(base, auth string, q MetricsQuery, debug bool) ([]BulkJobMetric, error) {
+	var metrics []BulkJobMetric
+	bodybytes, _, _, err := GetMetricsBulkJobsWithQuery(base, auth, q, debug)
+	return metrics, decodeMetrics(bodybytes, err, &metrics)
+}
+
+// GetEventMetrics returns event metrics
+func GetEventMetrics(base, auth string, q MetricsQuery, debug bool) ([]EventMetric, error) {
+	var metrics []EventMetric
+	bodybytes, _, _, err := GetMetricsEventsWithQuery(base, auth, q, debug)
+	return metrics, decodeMetrics(bodybytes, err, &metrics)
+}
+
+// GetFormulaExecutionMetrics returns formula execution metrics
+func GetFormulaExecutionMetrics(base, auth string, q MetricsQuery, debug bool) ([]FormulaExecutionMetric, error) {
+	var metrics []FormulaExecutionMetric
+	bodybytes, _, _, err := GetMetricsFormulaExecutionsWithQuery(base, auth, q, debug)
+	return metrics, decodeMetrics(bodybytes, err, &metrics)
+}
+
+// GetVDRInvokedMetrics returns VDR invocation metrics
+func GetVDRInvokedMetrics(base, auth string, q MetricsQuery, debug bool) ([]VDRMetric, error) {
+	var metrics []VDRMetric
+	bodybytes, _, _, err := GetMetricsVDRsInvokedWithQuery(base, auth, q, debug)
+	return metrics, decodeMetrics(bodybytes, err, &metrics)
+}
+
+// GetHubAPIMetrics returns hub API call metrics
+func GetHubAPIMetrics(base, auth string, q MetricsQuery, debug bool) ([]HubMetric, error) {
+	var metrics []HubMetric
+	bodybytes, _, _, err := GetMetricsHubAPIWithQuery(base, auth, q, debug)
+	return metrics, decodeMetrics(bodybytes, err, &metrics)
+}
+
+// GetCreatedMetrics returns creation metrics for one of the *-created
+// endpoints, such as MetricsFormulasCreated or MetricsHubsCreated
+func GetCreatedMetrics(base, auth string, uri string, q MetricsQuery, debug bool) ([]CreatedMetric, error) {
+	var metrics []CreatedMetric
+	bodybytes, _, _, err := GetJSONMetricsFor(metricsURL(base, uri, q), base, auth, debug)
+	return metrics, decodeMetrics(bodybytes, err, &metrics)
+}
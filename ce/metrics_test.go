@@ -0,0 +1,51 @@
+package ce
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetricsQueryValues(t *testing.T) {
+	q := MetricsQuery{
+		From:        time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC),
+		Interval:    MetricsIntervalDay,
+		AccountIDs:  []int{1, 2},
+		ElementKeys: []string{"sfdc"},
+		InstanceIDs: []int{452326},
+	}
+	expected := "/metrics/api?accountIds%5B%5D=1&accountIds%5B%5D=2&elementKeys%5B%5D=sfdc&from=2018-01-01T00%3A00%3A00Z&instanceIds%5B%5D=452326&interval=day&to=2018-02-01T00%3A00%3A00Z"
+	if u := metricsURL("", MetricsAPI, q); u != expected {
+		t.Errorf("unexpected url %s", u)
+	}
+	if u := metricsURL("", MetricsAPI, MetricsQuery{}); u != MetricsAPI {
+		t.Errorf("unexpected url for empty query %s", u)
+	}
+}
+
+func TestGetAPIMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != MetricsAPI || r.URL.Query().Get("interval") != "hour" {
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprint(w, `[{"timestamp":"2018-01-01T00:00:00Z","accountId":1,"elementKey":"sfdc","instanceId":452326,"count":10,"success":9,"failed":1}]`)
+	}))
+	defer ts.Close()
+
+	metrics, err := GetAPIMetrics(ts.URL, auth, MetricsQuery{Interval: MetricsIntervalHour}, false)
+	if err != nil {
+		t.Errorf("error: %s", err)
+	}
+	if len(metrics) != 1 || metrics[0].ElementKey != "sfdc" || metrics[0].Failed != 1 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+
+	_, err = GetAPIMetrics(ts.URL, auth, MetricsQuery{}, false)
+	if err == nil {
+		t.Errorf("expected error for non-200 status")
+	}
+}
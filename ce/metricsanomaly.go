@@ -0,0 +1,318 @@
+package ce
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Anomaly kinds
+const (
+	AnomalyAPIErrorRatio   = "api-error-ratio"
+	AnomalyFormulaFailures = "formula-failures"
+)
+
+// Anomaly severities
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// AnomalyOptions tunes the rolling baseline used to detect anomalies
+type AnomalyOptions struct {
+	// Window is the number of preceding buckets in the baseline
+	Window int
+	// MinSamples is the number of preceding buckets needed before a bucket is scored
+	MinSamples int
+	// Threshold is the z-score at which a bucket is a warning;
+	// twice the threshold is critical
+	Threshold float64
+	// Interval is the bucket size used for raw Formula Instance Executions
+	Interval time.Duration
+}
+
+// DefaultAnomalyOptions is a 24 bucket baseline with a z-score threshold of 3
+var DefaultAnomalyOptions = AnomalyOptions{
+	Window:     24,
+	MinSamples: 6,
+	Threshold:  3,
+	Interval:   time.Hour,
+}
+
+// AnomalyFinding is a time bucket where a series deviated from its baseline
+type AnomalyFinding struct {
+	Kind              string    `json:"kind"`
+	Severity          string    `json:"severity"`
+	ElementKey        string    `json:"elementKey,omitempty"`
+	FormulaID         int       `json:"formulaId,omitempty"`
+	FormulaInstanceID int       `json:"formulaInstanceId,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+	Value             float64   `json:"value"`
+	Mean              float64   `json:"mean"`
+	StdDev            float64   `json:"stdDev"`
+	ZScore            float64   `json:"zScore"`
+}
+
+// point is a single value in a time series
+type point struct {
+	t time.Time
+	v float64
+}
+
+// DetectAnomalies retrieves API and formula execution metrics for the query
+// and returns error ratio and failure anomalies, most severe first
+func DetectAnomalies(base, auth string, q MetricsQuery, opts AnomalyOptions, debug bool) ([]AnomalyFinding, error) {
+	api, err := GetAPIMetrics(base, auth, q, debug)
+	if err != nil {
+		return nil, err
+	}
+	executions, err := GetFormulaExecutionMetrics(base, auth, q, debug)
+	if err != nil {
+		return nil, err
+	}
+	findings := DetectAPIErrorAnomalies(api, opts)
+	findings = append(findings, DetectFormulaFailureAnomalies(executions, opts)...)
+	sortFindings(findings)
+	return findings, nil
+}
+
+// DetectFormulaInstanceAnomalies retrieves every Execution of a Formula
+// Instance and returns buckets with anomalous failure counts
+func DetectFormulaInstanceAnomalies(base, auth string, formulaInstanceID string, opts AnomalyOptions) ([]AnomalyFinding, error) {
+	executions, err := GetAllFormulaInstanceExecutions(base, auth, formulaInstanceID)
+	if err != nil {
+		return nil, err
+	}
+	return DetectExecutionFailureAnomalies(executions, opts), nil
+}
+
+// DetectAPIErrorAnomalies flags buckets where an element's API error ratio
+// (failed / count) deviates from its rolling baseline; buckets without calls
+// have a ratio of zero
+func DetectAPIErrorAnomalies(metrics []APIMetric, opts AnomalyOptions) []AnomalyFinding {
+	type bucket struct{ count, failed int }
+	byElement := make(map[string]map[time.Time]*bucket)
+	var timestamps []time.Time
+	for _, m := range metrics {
+		timestamps = append(timestamps, m.Timestamp)
+		buckets, ok := byElement[m.ElementKey]
+		if !ok {
+			buckets = make(map[time.Time]*bucket)
+			byElement[m.ElementKey] = buckets
+		}
+		b, ok := buckets[m.Timestamp]
+		if !ok {
+			b = &bucket{}
+			buckets[m.Timestamp] = b
+		}
+		b.count += m.Count
+		b.failed += m.Failed
+	}
+
+	interval := metricsInterval(timestamps)
+	var findings []AnomalyFinding
+	for key, buckets := range byElement {
+		ratios := make(map[time.Time]float64)
+		for t, b := range buckets {
+			ratios[t] += 0
+			if b.count > 0 {
+				ratios[t] = float64(b.failed) / float64(b.count)
+			}
+		}
+		fillBuckets(ratios, interval)
+		for _, f := range scoreSeries(seriesOf(ratios), opts, 0.01) {
+			f.Kind = AnomalyAPIErrorRatio
+			f.ElementKey = key
+			findings = append(findings, f)
+		}
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// DetectFormulaFailureAnomalies flags buckets where a formula's failure count
+// deviates from its rolling baseline; buckets missing from the metrics count
+// as zero failures
+func DetectFormulaFailureAnomalies(metrics []FormulaExecutionMetric, opts AnomalyOptions) []AnomalyFinding {
+	byFormula := make(map[int]map[time.Time]float64)
+	var timestamps []time.Time
+	for _, m := range metrics {
+		timestamps = append(timestamps, m.Timestamp)
+		if byFormula[m.FormulaID] == nil {
+			byFormula[m.FormulaID] = make(map[time.Time]float64)
+		}
+		byFormula[m.FormulaID][m.Timestamp] += float64(m.Failed)
+	}
+
+	interval := metricsInterval(timestamps)
+	var findings []AnomalyFinding
+	for id, buckets := range byFormula {
+		fillBuckets(buckets, interval)
+		for _, f := range scoreSeries(seriesOf(buckets), opts, 1) {
+			f.Kind = AnomalyFormulaFailures
+			f.FormulaID = id
+			findings = append(findings, f)
+		}
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// DetectExecutionFailureAnomalies buckets raw Formula Instance Executions by
+// opts.Interval, counting intervals without executions as zero failures, and
+// flags buckets with anomalous failure counts
+func DetectExecutionFailureAnomalies(executions []FormulaInstanceExecution, opts AnomalyOptions) []AnomalyFinding {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultAnomalyOptions.Interval
+	}
+	byInstance := make(map[int]map[time.Time]float64)
+	for _, e := range executions {
+		if byInstance[e.FormulaInstanceID] == nil {
+			byInstance[e.FormulaInstanceID] = make(map[time.Time]float64)
+		}
+		t := e.CreateDate.Truncate(interval)
+		byInstance[e.FormulaInstanceID][t] += 0
+		if e.Status == "failed" {
+			byInstance[e.FormulaInstanceID][t]++
+		}
+	}
+
+	var findings []AnomalyFinding
+	for id, buckets := range byInstance {
+		fillBuckets(buckets, interval)
+		for _, f := range scoreSeries(seriesOf(buckets), opts, 1) {
+			f.Kind = AnomalyFormulaFailures
+			f.FormulaInstanceID = id
+			findings = append(findings, f)
+		}
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// metricsInterval returns the bucket size of metrics, the smallest gap
+// between their timestamps, or zero when it can't be told
+func metricsInterval(timestamps []time.Time) time.Duration {
+	sorted := append([]time.Time{}, timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	var interval time.Duration
+	for i := 1; i < len(sorted); i++ {
+		gap := sorted[i].Sub(sorted[i-1])
+		if gap > 0 && (interval == 0 || gap < interval) {
+			interval = gap
+		}
+	}
+	return interval
+}
+
+// fillBuckets adds zero buckets for the intervals without executions between
+// the first and last bucket, so quiet periods count in the baseline
+func fillBuckets(buckets map[time.Time]float64, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	var first, last time.Time
+	for t := range buckets {
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	if first.IsZero() {
+		return
+	}
+	for t := first.Add(interval); t.Before(last); t = t.Add(interval) {
+		buckets[t] += 0
+	}
+}
+
+func seriesOf(buckets map[time.Time]float64) []point {
+	series := make([]point, 0, len(buckets))
+	for t, v := range buckets {
+		series = append(series, point{t, v})
+	}
+	return series
+}
+
+// scoreSeries orders a series by time and scores each point against the mean
+// and standard deviation of the preceding window; minStdDev keeps a perfectly
+// flat baseline from flagging every tiny change
+func scoreSeries(series []point, opts AnomalyOptions, minStdDev float64) []AnomalyFinding {
+	if opts.Window <= 0 {
+		opts.Window = DefaultAnomalyOptions.Window
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = DefaultAnomalyOptions.MinSamples
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultAnomalyOptions.Threshold
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].t.Before(series[j].t) })
+
+	var findings []AnomalyFinding
+	for i, p := range series {
+		start := i - opts.Window
+		if start < 0 {
+			start = 0
+		}
+		baseline := series[start:i]
+		if len(baseline) < opts.MinSamples {
+			continue
+		}
+		mean, stddev := meanStdDev(baseline)
+		z := (p.v - mean) / math.Max(stddev, minStdDev)
+		if z < opts.Threshold {
+			continue
+		}
+		severity := SeverityWarning
+		if z >= 2*opts.Threshold {
+			severity = SeverityCritical
+		}
+		findings = append(findings, AnomalyFinding{
+			Severity:  severity,
+			Timestamp: p.t,
+			Value:     p.v,
+			Mean:      mean,
+			StdDev:    stddev,
+			ZScore:    z,
+		})
+	}
+	return findings
+}
+
+func meanStdDev(points []point) (float64, float64) {
+	var sum float64
+	for _, p := range points {
+		sum += p.v
+	}
+	mean := sum / float64(len(points))
+	var sq float64
+	for _, p := range points {
+		sq += (p.v - mean) * (p.v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(points)))
+}
+
+// sortFindings orders findings critical first, then by z-score descending
+func sortFindings(findings []AnomalyFinding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity == SeverityCritical
+		}
+		if findings[i].ZScore != findings[j].ZScore {
+			return findings[i].ZScore > findings[j].ZScore
+		}
+		return findings[i].subject() < findings[j].subject()
+	})
+}
+
+func (f AnomalyFinding) subject() string {
+	if f.ElementKey != "" {
+		return f.ElementKey
+	}
+	return strconv.Itoa(f.FormulaID) + "/" + strconv.Itoa(f.FormulaInstanceID)
+}
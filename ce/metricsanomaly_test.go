@@ -0,0 +1,109 @@
+package ce
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetectAPIErrorAnomalies(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var metrics []APIMetric
+	for i := 0; i < 12; i++ {
+		failed := 1
+		if i == 10 {
+			failed = 50
+		}
+		metrics = append(metrics,
+			APIMetric{Timestamp: start.Add(time.Duration(i) * time.Hour), ElementKey: "sfdc", Count: 100, Failed: failed},
+			APIMetric{Timestamp: start.Add(time.Duration(i) * time.Hour), ElementKey: "hubspot", Count: 100, Failed: 2},
+		)
+	}
+	findings := DetectAPIErrorAnomalies(metrics, DefaultAnomalyOptions)
+	if len(findings) != 1 {
+		t.Fatalf("expected 1 finding, got %+v", findings)
+	}
+	f := findings[0]
+	if f.ElementKey != "sfdc" || f.Kind != AnomalyAPIErrorRatio || f.Severity != SeverityCritical || !f.Timestamp.Equal(start.Add(10*time.Hour)) {
+		t.Errorf("unexpected finding %+v", f)
+	}
+}
+
+func TestDetectExecutionFailureAnomalies(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var executions []FormulaInstanceExecution
+	for i := 0; i < 10; i++ {
+		executions = append(executions, FormulaInstanceExecution{
+			FormulaInstanceID: 4,
+			Status:            "success",
+			CreateDate:        start.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	for i := 0; i < 4; i++ {
+		executions = append(executions, FormulaInstanceExecution{
+			FormulaInstanceID: 4,
+			Status:            "failed",
+			CreateDate:        start.Add(9*time.Hour + time.Duration(i)*time.Minute),
+		})
+	}
+	findings := DetectExecutionFailureAnomalies(executions, DefaultAnomalyOptions)
+	if len(findings) != 1 || findings[0].FormulaInstanceID != 4 || findings[0].Value != 4 || findings[0].Severity != SeverityWarning {
+		t.Errorf("unexpected findings %+v", findings)
+	}
+}
+
+func TestDetectExecutionFailureAnomaliesQuietHours(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	executions := []FormulaInstanceExecution{
+		{FormulaInstanceID: 4, Status: "success", CreateDate: start},
+		{FormulaInstanceID: 4, Status: "success", CreateDate: start.Add(8 * time.Hour)},
+	}
+	for i := 0; i < 4; i++ {
+		executions = append(executions, FormulaInstanceExecution{
+			FormulaInstanceID: 4,
+			Status:            "failed",
+			CreateDate:        start.Add(9*time.Hour + time.Duration(i)*time.Minute),
+		})
+	}
+	findings := DetectExecutionFailureAnomalies(executions, DefaultAnomalyOptions)
+	if len(findings) != 1 || !findings[0].Timestamp.Equal(start.Add(9*time.Hour)) || findings[0].Mean != 0 {
+		t.Errorf("unexpected findings %+v", findings)
+	}
+}
+
+func TestDetectFormulaFailureAnomaliesQuietHours(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	metrics := []FormulaExecutionMetric{
+		{Timestamp: start, FormulaID: 3, Count: 5},
+		{Timestamp: start.Add(8 * time.Hour), FormulaID: 3, Count: 5},
+		{Timestamp: start.Add(9 * time.Hour), FormulaID: 3, Count: 5, Failed: 4},
+	}
+	// another formula reports every hour, setting the bucket size
+	for i := 0; i < 10; i++ {
+		metrics = append(metrics, FormulaExecutionMetric{Timestamp: start.Add(time.Duration(i) * time.Hour), FormulaID: 8, Count: 1})
+	}
+	findings := DetectFormulaFailureAnomalies(metrics, DefaultAnomalyOptions)
+	if len(findings) != 1 || findings[0].FormulaID != 3 || !findings[0].Timestamp.Equal(start.Add(9*time.Hour)) || findings[0].Mean != 0 {
+		t.Errorf("unexpected findings %+v", findings)
+	}
+}
+
+func TestDetectFormulaInstanceAnomaliesPaged(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var executions []FormulaInstanceExecution
+	for i := 0; i < 4; i++ {
+		executions = append(executions, FormulaInstanceExecution{ID: 20 - i, FormulaInstanceID: 4, Status: "failed", CreateDate: start.Add(9*time.Hour + time.Duration(i)*time.Minute)})
+	}
+	for i := 8; i >= 0; i-- {
+		executions = append(executions, FormulaInstanceExecution{ID: i + 1, FormulaInstanceID: 4, Status: "success", CreateDate: start.Add(time.Duration(i) * time.Hour)})
+	}
+	ts := pagedExecutionsServer(t, "4", executions, 3)
+	defer ts.Close()
+
+	findings, err := DetectFormulaInstanceAnomalies(ts.URL, auth, "4", DefaultAnomalyOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || !findings[0].Timestamp.Equal(start.Add(9*time.Hour)) {
+		t.Errorf("expected a finding built from every page, got %+v", findings)
+	}
+}
@@ -0,0 +1,284 @@
+package ce
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Usage kinds reported by a UsageReport
+const (
+	UsageAPI               = "api"
+	UsageEvents            = "events"
+	UsageFormulaExecutions = "formula-executions"
+)
+
+// Report output formats
+const (
+	ReportFormatMarkdown = "markdown"
+	ReportFormatCSV      = "csv"
+	ReportFormatJSON     = "json"
+	ReportFormatTable    = "table"
+)
+
+// UsagePeriod holds the metrics for one period of a usage report
+type UsagePeriod struct {
+	From              time.Time                `json:"from"`
+	To                time.Time                `json:"to"`
+	API               []APIMetric              `json:"api"`
+	Events            []EventMetric            `json:"events"`
+	FormulaExecutions []FormulaExecutionMetric `json:"formulaExecutions"`
+}
+
+// UsageRow is the traffic of one element instance or formula instance across both periods
+type UsageRow struct {
+	Kind              string  `json:"kind"`
+	ElementKey        string  `json:"elementKey,omitempty"`
+	InstanceID        int     `json:"instanceId,omitempty"`
+	FormulaID         int     `json:"formulaId,omitempty"`
+	FormulaInstanceID int     `json:"formulaInstanceId,omitempty"`
+	Previous          int     `json:"previous"`
+	Current           int     `json:"current"`
+	Delta             int     `json:"delta"`
+	DeltaPercent      float64 `json:"deltaPercent"`
+}
+
+// UsageReport compares usage by element and formula over two periods
+type UsageReport struct {
+	PreviousFrom time.Time  `json:"previousFrom"`
+	PreviousTo   time.Time  `json:"previousTo"`
+	CurrentFrom  time.Time  `json:"currentFrom"`
+	CurrentTo    time.Time  `json:"currentTo"`
+	Rows         []UsageRow `json:"rows"`
+	TopMovers    []UsageRow `json:"topMovers"`
+}
+
+// Subject describes what a row counts, such as "sfdc/452326" or "formula 19547/199701"
+func (r UsageRow) Subject() string {
+	if r.Kind == UsageFormulaExecutions {
+		return fmt.Sprintf("formula %v/%v", r.FormulaID, r.FormulaInstanceID)
+	}
+	return fmt.Sprintf("%s/%v", r.ElementKey, r.InstanceID)
+}
+
+// GetUsagePeriod retrieves the API, event and formula execution metrics for the query's time range
+func GetUsagePeriod(base, auth string, q MetricsQuery, debug bool) (UsagePeriod, error) {
+	period := UsagePeriod{From: q.From, To: q.To}
+	var err error
+	period.API, err = GetAPIMetrics(base, auth, q, debug)
+	if err != nil {
+		return period, fmt.Errorf("api metrics: %s", err)
+	}
+	period.Events, err = GetEventMetrics(base, auth, q, debug)
+	if err != nil {
+		return period, fmt.Errorf("event metrics: %s", err)
+	}
+	period.FormulaExecutions, err = GetFormulaExecutionMetrics(base, auth, q, debug)
+	if err != nil {
+		return period, fmt.Errorf("formula execution metrics: %s", err)
+	}
+	return period, nil
+}
+
+// UsageReportFor retrieves both periods from the Platform and builds a UsageReport
+func UsageReportFor(base, auth string, previous, current MetricsQuery, topN int, debug bool) (UsageReport, error) {
+	p, err := GetUsagePeriod(base, auth, previous, debug)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	c, err := GetUsagePeriod(base, auth, current, debug)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	return BuildUsageReport(p, c, topN), nil
+}
+
+// BuildUsageReport aggregates each period by element instance and formula
+// instance, computes deltas and selects the topN rows with the largest
+// absolute change
+func BuildUsageReport(previous, current UsagePeriod, topN int) UsageReport {
+	rows := make(map[usageKey]*UsageRow)
+	row := func(key usageKey) *UsageRow {
+		r, ok := rows[key]
+		if !ok {
+			r = &UsageRow{
+				Kind:              key.Kind,
+				ElementKey:        key.ElementKey,
+				InstanceID:        key.InstanceID,
+				FormulaID:         key.FormulaID,
+				FormulaInstanceID: key.FormulaInstanceID,
+			}
+			rows[key] = r
+		}
+		return r
+	}
+	accumulate := func(p UsagePeriod, add func(r *UsageRow, n int)) {
+		for _, m := range p.API {
+			add(row(usageKey{Kind: UsageAPI, ElementKey: m.ElementKey, InstanceID: m.InstanceID}), m.Count)
+		}
+		for _, m := range p.Events {
+			add(row(usageKey{Kind: UsageEvents, ElementKey: m.ElementKey, InstanceID: m.InstanceID}), m.Count)
+		}
+		for _, m := range p.FormulaExecutions {
+			add(row(usageKey{Kind: UsageFormulaExecutions, FormulaID: m.FormulaID, FormulaInstanceID: m.FormulaInstanceID}), m.Count)
+		}
+	}
+	accumulate(previous, func(r *UsageRow, n int) { r.Previous += n })
+	accumulate(current, func(r *UsageRow, n int) { r.Current += n })
+
+	report := UsageReport{
+		PreviousFrom: previous.From,
+		PreviousTo:   previous.To,
+		CurrentFrom:  current.From,
+		CurrentTo:    current.To,
+	}
+	for _, r := range rows {
+		r.Delta = r.Current - r.Previous
+		if r.Previous != 0 {
+			r.DeltaPercent = float64(r.Delta) / float64(r.Previous) * 100
+		}
+		report.Rows = append(report.Rows, *r)
+	}
+	sort.Sort(byUsage(report.Rows))
+
+	movers := make([]UsageRow, len(report.Rows))
+	copy(movers, report.Rows)
+	sort.SliceStable(movers, func(i, j int) bool {
+		return abs(movers[i].Delta) > abs(movers[j].Delta)
+	})
+	for _, r := range movers {
+		if len(report.TopMovers) >= topN || r.Delta == 0 {
+			break
+		}
+		report.TopMovers = append(report.TopMovers, r)
+	}
+	return report
+}
+
+// usageKey identifies the element instance or formula instance a UsageRow counts
+type usageKey struct {
+	Kind              string
+	ElementKey        string
+	InstanceID        int
+	FormulaID         int
+	FormulaInstanceID int
+}
+
+// byUsage orders rows by kind, then by current usage descending
+type byUsage []UsageRow
+
+func (u byUsage) Len() int      { return len(u) }
+func (u byUsage) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+func (u byUsage) Less(i, j int) bool {
+	if u[i].Kind != u[j].Kind {
+		return u[i].Kind < u[j].Kind
+	}
+	if u[i].Current != u[j].Current {
+		return u[i].Current > u[j].Current
+	}
+	return u[i].Subject() < u[j].Subject()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// WriteUsageReport renders a UsageReport as markdown, csv, json or an ASCII table
+func WriteUsageReport(w io.Writer, report UsageReport, format string) error {
+	switch format {
+	case ReportFormatMarkdown:
+		return writeUsageMarkdown(w, report)
+	case ReportFormatTable:
+		return writeUsageTable(w, report)
+	case ReportFormatCSV:
+		return writeUsageCSV(w, report)
+	case ReportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return fmt.Errorf("unknown report format %s", format)
+}
+
+func usageRecord(r UsageRow) []string {
+	return []string{
+		r.Kind,
+		r.Subject(),
+		strconv.Itoa(r.Previous),
+		strconv.Itoa(r.Current),
+		strconv.Itoa(r.Delta),
+		strconv.FormatFloat(r.DeltaPercent, 'f', 1, 64),
+	}
+}
+
+func writeUsageCSV(w io.Writer, report UsageReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "subject", "previous", "current", "delta", "delta%"})
+	for _, r := range report.Rows {
+		cw.Write(usageRecord(r))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeUsageTable(w io.Writer, report UsageReport) error {
+	const layout = "2006-01-02"
+	fmt.Fprintf(w, "Previous period: %s to %s\nCurrent period: %s to %s\n\n",
+		report.PreviousFrom.Format(layout), report.PreviousTo.Format(layout),
+		report.CurrentFrom.Format(layout), report.CurrentTo.Format(layout))
+	data := [][]string{}
+	for _, r := range report.Rows {
+		data = append(data, usageRecord(r))
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Kind", "Subject", "Previous", "Current", "Delta", "Delta %"})
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+	return nil
+}
+
+func writeUsageMarkdown(w io.Writer, report UsageReport) error {
+	const layout = "2006-01-02"
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Usage report\n\n")
+	fmt.Fprintf(&b, "Previous period: %s to %s  \nCurrent period: %s to %s\n",
+		report.PreviousFrom.Format(layout), report.PreviousTo.Format(layout),
+		report.CurrentFrom.Format(layout), report.CurrentTo.Format(layout))
+
+	table := func(title string, rows []UsageRow) {
+		fmt.Fprintf(&b, "\n## %s\n\n", title)
+		if len(rows) == 0 {
+			fmt.Fprintf(&b, "No usage.\n")
+			return
+		}
+		fmt.Fprintf(&b, "| Kind | Subject | Previous | Current | Delta | Delta %% |\n")
+		fmt.Fprintf(&b, "|---|---|---:|---:|---:|---:|\n")
+		for _, r := range rows {
+			fmt.Fprintf(&b, "| %s |\n", strings.Join(usageRecord(r), " | "))
+		}
+	}
+	table("Top movers", report.TopMovers)
+	for _, kind := range []string{UsageAPI, UsageEvents, UsageFormulaExecutions} {
+		var rows []UsageRow
+		for _, r := range report.Rows {
+			if r.Kind == kind {
+				rows = append(rows, r)
+			}
+		}
+		table(kind, rows)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,60 @@
+package ce
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuildUsageReport(t *testing.T) {
+	previous := UsagePeriod{
+		API: []APIMetric{
+			{ElementKey: "sfdc", InstanceID: 1, Count: 100},
+			{ElementKey: "hubspot", InstanceID: 2, Count: 10},
+		},
+		FormulaExecutions: []FormulaExecutionMetric{
+			{FormulaID: 3, FormulaInstanceID: 4, Count: 5},
+		},
+	}
+	current := UsagePeriod{
+		API: []APIMetric{
+			{ElementKey: "sfdc", InstanceID: 1, Count: 60},
+			{ElementKey: "sfdc", InstanceID: 1, Count: 60},
+			{ElementKey: "hubspot", InstanceID: 2, Count: 60},
+		},
+		Events: []EventMetric{
+			{ElementKey: "sfdc", InstanceID: 1, Count: 7},
+		},
+		FormulaExecutions: []FormulaExecutionMetric{
+			{FormulaID: 3, FormulaInstanceID: 4, Count: 5},
+		},
+	}
+	report := BuildUsageReport(previous, current, 2)
+	if len(report.Rows) != 4 {
+		t.Errorf("expected 4 rows, got %+v", report.Rows)
+	}
+	if len(report.TopMovers) != 2 {
+		t.Fatalf("expected 2 top movers, got %+v", report.TopMovers)
+	}
+	top := report.TopMovers[0]
+	if top.ElementKey != "hubspot" || top.Delta != 50 || top.DeltaPercent != 500 {
+		t.Errorf("unexpected top mover %+v", top)
+	}
+	if report.TopMovers[1].ElementKey != "sfdc" || report.TopMovers[1].Kind != UsageAPI {
+		t.Errorf("unexpected second mover %+v", report.TopMovers[1])
+	}
+
+	for _, format := range []string{ReportFormatMarkdown, ReportFormatCSV, ReportFormatJSON, ReportFormatTable} {
+		var buf bytes.Buffer
+		err := WriteUsageReport(&buf, report, format)
+		if err != nil {
+			t.Errorf("%s: %s", format, err)
+		}
+		if !strings.Contains(buf.String(), "hubspot") {
+			t.Errorf("%s output missing rows:\n%s", format, buf.String())
+		}
+	}
+	if err := WriteUsageReport(&bytes.Buffer{}, report, "xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
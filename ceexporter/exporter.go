@@ -0,0 +1,284 @@
+// Package ceexporter serves Cloud Elements Platform metrics in the
+// Prometheus text exposition format
+package ceexporter
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+)
+
+const (
+	// DefaultWindow is how far back each scrape queries the Platform
+	DefaultWindow = time.Hour
+	// DefaultTTL is how long a scrape is cached before the Platform is queried again
+	DefaultTTL = 5 * time.Minute
+
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Exporter is an http.Handler that scrapes the Platform metrics endpoints and
+// renders them for Prometheus; results are cached for TTL between scrapes
+type Exporter struct {
+	Base  string
+	Auth  string
+	Debug bool
+	// Window is the time range queried on each scrape, ending now
+	Window time.Duration
+	// TTL is how long scraped results are served from cache
+	TTL time.Duration
+	// Query holds additional filters (accounts, elements, instances);
+	// From and To are set from Window on each scrape
+	Query ce.MetricsQuery
+
+	mu      sync.Mutex
+	cached  []byte
+	scraped time.Time
+	now     func() time.Time
+}
+
+// New returns an Exporter for the given Platform base URL and Authorization header
+func New(base, auth string) *Exporter {
+	return &Exporter{
+		Base:   base,
+		Auth:   auth,
+		Window: DefaultWindow,
+		TTL:    DefaultTTL,
+		now:    time.Now,
+	}
+}
+
+// ServeHTTP writes the cached metrics, scraping the Platform if the cache has expired
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := e.Metrics()
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// Metrics returns the exposition-format metrics, scraping if the cache has expired
+func (e *Exporter) Metrics() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cached != nil && e.clock().Sub(e.scraped) < e.TTL {
+		return e.cached
+	}
+	e.cached = e.scrape()
+	e.scraped = e.clock()
+	return e.cached
+}
+
+// Run refreshes the cache every TTL, or DefaultTTL when TTL isn't positive,
+// until stop is closed, so Prometheus scrapes never wait on the Platform
+func (e *Exporter) Run(stop <-chan struct{}) {
+	ttl := e.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	ticker := time.NewTicker(ttl)
+	defer ticker.Stop()
+	e.refresh()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			e.refresh()
+		}
+	}
+}
+
+func (e *Exporter) refresh() {
+	body := e.scrape()
+	e.mu.Lock()
+	e.cached = body
+	e.scraped = e.clock()
+	e.mu.Unlock()
+}
+
+func (e *Exporter) clock() time.Time {
+	if e.now == nil {
+		return time.Now()
+	}
+	return e.now()
+}
+
+// scrape queries every metrics endpoint and renders the results; an endpoint
+// that fails is reported through ce_scrape_success rather than failing the scrape
+func (e *Exporter) scrape() []byte {
+	start := e.clock()
+	q := e.Query
+	q.To = start
+	q.From = start.Add(-e.Window)
+
+	fams := newFamilies()
+	success := fams.family("ce_scrape_success", "gauge", "Whether the last scrape of a Platform metrics endpoint succeeded")
+
+	record := func(endpoint string, err error) {
+		if err != nil {
+			if e.Debug {
+				log.Printf("scrape %s failed: %s", endpoint, err)
+			}
+			success.set(0, "endpoint", endpoint)
+			return
+		}
+		success.set(1, "endpoint", endpoint)
+	}
+
+	api, err := ce.GetAPIMetrics(e.Base, e.Auth, q, e.Debug)
+	record(ce.MetricsAPI, err)
+	calls := fams.family("ce_api_calls", "gauge", "API calls in the scrape window")
+	failed := fams.family("ce_api_calls_failed", "gauge", "Failed API calls in the scrape window")
+	for _, m := range api {
+		labels := instanceLabels(m.AccountID, m.ElementKey, m.InstanceID)
+		calls.add(float64(m.Count), labels...)
+		failed.add(float64(m.Failed), labels...)
+	}
+
+	bulk, err := ce.GetBulkJobMetrics(e.Base, e.Auth, q, e.Debug)
+	record(ce.MetricsBulkJobsAPI, err)
+	jobs := fams.family("ce_bulk_jobs", "gauge", "Bulk jobs in the scrape window")
+	records := fams.family("ce_bulk_job_records", "gauge", "Records processed by bulk jobs in the scrape window")
+	for _, m := range bulk {
+		labels := instanceLabels(m.AccountID, m.ElementKey, m.InstanceID)
+		jobs.add(float64(m.Count), labels...)
+		records.add(float64(m.Records), labels...)
+	}
+
+	events, err := ce.GetEventMetrics(e.Base, e.Auth, q, e.Debug)
+	record(ce.MetricsEvents, err)
+	eventCount := fams.family("ce_events", "gauge", "Events received in the scrape window")
+	for _, m := range events {
+		eventCount.add(float64(m.Count), instanceLabels(m.AccountID, m.ElementKey, m.InstanceID)...)
+	}
+
+	executions, err := ce.GetFormulaExecutionMetrics(e.Base, e.Auth, q, e.Debug)
+	record(ce.MetricsFormulaExecutions, err)
+	execCount := fams.family("ce_formula_executions", "gauge", "Formula executions in the scrape window, by status")
+	for _, m := range executions {
+		labels := []string{
+			"account", strconv.Itoa(m.AccountID),
+			"formula", strconv.Itoa(m.FormulaID),
+			"formula_instance", strconv.Itoa(m.FormulaInstanceID),
+		}
+		execCount.add(float64(m.Success), append(labels, "status", "success")...)
+		execCount.add(float64(m.Failed), append(labels, "status", "failed")...)
+		execCount.add(float64(m.Cancelled), append(labels, "status", "cancelled")...)
+	}
+
+	vdrs, err := ce.GetVDRInvokedMetrics(e.Base, e.Auth, q, e.Debug)
+	record(ce.MetricsVDRsInvoked, err)
+	vdrCount := fams.family("ce_vdr_invocations", "gauge", "VDR invocations in the scrape window")
+	for _, m := range vdrs {
+		vdrCount.add(float64(m.Count), append(instanceLabels(m.AccountID, m.ElementKey, m.InstanceID), "object", m.ObjectName)...)
+	}
+
+	hubs, err := ce.GetHubAPIMetrics(e.Base, e.Auth, q, e.Debug)
+	record(ce.MetricsHubAPI, err)
+	hubCount := fams.family("ce_hub_api_calls", "gauge", "Hub API calls in the scrape window")
+	for _, m := range hubs {
+		hubCount.add(float64(m.Count), append(instanceLabels(m.AccountID, m.ElementKey, m.InstanceID), "hub", m.Hub)...)
+	}
+
+	created := fams.family("ce_created", "gauge", "Resources created in the scrape window")
+	for resource, uri := range map[string]string{
+		"elements":          ce.MetricsElementsCreated,
+		"element_instances": ce.MetricsElementInstancesCreated,
+		"formulas":          ce.MetricsFormulasCreated,
+		"vdrs":              ce.MetricsVDRsCreated,
+		"hubs":              ce.MetricsHubsCreated,
+	} {
+		metrics, err := ce.GetCreatedMetrics(e.Base, e.Auth, uri, q, e.Debug)
+		record(uri, err)
+		for _, m := range metrics {
+			created.add(float64(m.Count), "account", strconv.Itoa(m.AccountID), "resource", resource)
+		}
+	}
+
+	duration := fams.family("ce_scrape_duration_seconds", "gauge", "Time taken to scrape the Platform metrics endpoints")
+	duration.set(e.clock().Sub(start).Seconds())
+
+	return fams.render()
+}
+
+func instanceLabels(accountID int, elementKey string, instanceID int) []string {
+	return []string{
+		"account", strconv.Itoa(accountID),
+		"element", elementKey,
+		"instance", strconv.Itoa(instanceID),
+	}
+}
+
+// families is an ordered set of metric families
+type families struct {
+	order []*family
+}
+
+// family is a metric name with its samples, keyed by rendered label set
+type family struct {
+	name    string
+	kind    string
+	help    string
+	samples map[string]float64
+}
+
+func newFamilies() *families {
+	return &families{}
+}
+
+func (fs *families) family(name, kind, help string) *family {
+	f := &family{name: name, kind: kind, help: help, samples: make(map[string]float64)}
+	fs.order = append(fs.order, f)
+	return f
+}
+
+// add sums v into the sample for the given label name/value pairs
+func (f *family) add(v float64, labels ...string) {
+	f.samples[renderLabels(labels)] += v
+}
+
+// set replaces the sample for the given label name/value pairs
+func (f *family) set(v float64, labels ...string) {
+	f.samples[renderLabels(labels)] = v
+}
+
+func (fs *families) render() []byte {
+	var buf bytes.Buffer
+	for _, f := range fs.order {
+		if len(f.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", f.name, f.kind)
+		keys := make([]string, 0, len(f.samples))
+		for k := range f.samples {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, "%s%s %s\n", f.name, k, strconv.FormatFloat(f.samples[k], 'g', -1, 64))
+		}
+	}
+	return buf.Bytes()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// renderLabels renders name/value pairs as {name="value",...}
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,84 @@
+package ceexporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+)
+
+func TestExporter(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case ce.MetricsAPI:
+			fmt.Fprint(w, `[
+				{"timestamp":"2018-01-01T00:00:00Z","accountId":1,"elementKey":"sfdc","instanceId":7,"count":10,"failed":1},
+				{"timestamp":"2018-01-01T01:00:00Z","accountId":1,"elementKey":"sfdc","instanceId":7,"count":5,"failed":2}
+			]`)
+		case ce.MetricsFormulaExecutions:
+			fmt.Fprint(w, `[{"timestamp":"2018-01-01T00:00:00Z","accountId":1,"formulaId":3,"formulaInstanceId":4,"count":3,"success":2,"failed":1}]`)
+		case ce.MetricsEvents:
+			w.WriteHeader(500)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer ts.Close()
+
+	e := New(ts.URL, "")
+	now := time.Date(2018, 1, 1, 2, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		`ce_api_calls{account="1",element="sfdc",instance="7"} 15`,
+		`ce_api_calls_failed{account="1",element="sfdc",instance="7"} 3`,
+		`ce_formula_executions{account="1",formula="3",formula_instance="4",status="failed"} 1`,
+		`ce_formula_executions{account="1",formula="3",formula_instance="4",status="success"} 2`,
+		`ce_scrape_success{endpoint="/metrics/events"} 0`,
+		`ce_scrape_success{endpoint="/metrics/api"} 1`,
+		`# TYPE ce_api_calls gauge`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+
+	scraped := calls
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	if calls != scraped {
+		t.Errorf("expected cached response, Platform called %v more times", calls-scraped)
+	}
+	now = now.Add(DefaultTTL)
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	if calls == scraped {
+		t.Errorf("expected scrape after TTL")
+	}
+
+	// Run falls back to DefaultTTL instead of panicking on a zero TTL
+	e.TTL = 0
+	stop := make(chan struct{})
+	close(stop)
+	scraped = calls
+	e.Run(stop)
+	if calls == scraped {
+		t.Errorf("expected Run to refresh before stopping")
+	}
+}
+
+func TestRenderLabels(t *testing.T) {
+	got := renderLabels([]string{"element", `a"b\c`})
+	if got != `{element="a\"b\\c"}` {
+		t.Errorf("unexpected labels %s", got)
+	}
+}